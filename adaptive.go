@@ -0,0 +1,47 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// adaptiveVerbose tracks the error rate of the queries over a tumbling window
+// and reports whether it exceeds the threshold.
+type adaptiveVerbose struct {
+	mu        sync.Mutex
+	threshold float64
+	window    time.Duration
+	start     time.Time
+	total     int
+	errors    int
+	now       func() time.Time
+}
+
+func newAdaptiveVerbose(threshold float64, window time.Duration) *adaptiveVerbose {
+	return &adaptiveVerbose{
+		threshold: threshold,
+		window:    window,
+		now:       time.Now,
+	}
+}
+
+// observe records a query outcome and reports whether verbose logging should
+// be on.
+func (a *adaptiveVerbose) observe(failed bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if now.Sub(a.start) >= a.window {
+		a.start = now
+		a.total = 0
+		a.errors = 0
+	}
+
+	a.total++
+	if failed {
+		a.errors++
+	}
+
+	return float64(a.errors)/float64(a.total) > a.threshold
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// errorBurst counts the errors over a tumbling window and tells when they
+// exceed the limit, once per window.
+type errorBurst struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	start   time.Time
+	errors  int
+	tripped bool
+	now     func() time.Time
+}
+
+func newErrorBurst(limit int, window time.Duration) *errorBurst {
+	return &errorBurst{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// observe records an error and reports whether the limit has just been
+// exceeded in the current window.
+func (b *errorBurst) observe() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if now.Sub(b.start) >= b.window {
+		b.start = now
+		b.errors = 0
+		b.tripped = false
+	}
+
+	b.errors++
+	if b.errors <= b.limit || b.tripped {
+		return false
+	}
+	b.tripped = true
+
+	return true
+}
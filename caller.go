@@ -0,0 +1,45 @@
+package db
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// hookDir is the directory of the hook sources, whose frames are skipped
+// when looking for the caller of a query.
+var hookDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// queryCaller returns the first frame outside bun and the hook, ascending skip
+// more frames, i.e. the call site of the query in the application.
+func queryCaller(skip int) (zapcore.EntryCaller, bool) {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	for {
+		frame, more := frames.Next()
+		if !isHookFrame(frame) {
+			if skip == 0 {
+				return zapcore.NewEntryCaller(frame.PC, frame.File, frame.Line, true), true
+			}
+			skip--
+		}
+		if !more {
+			return zapcore.EntryCaller{}, false
+		}
+	}
+}
+
+// isHookFrame reports whether the frame belongs to bun or to the hook.
+func isHookFrame(frame runtime.Frame) bool {
+	if strings.HasPrefix(frame.Function, "github.com/uptrace/bun.") || strings.HasPrefix(frame.Function, "github.com/uptrace/bun/") {
+		return true
+	}
+
+	return filepath.Dir(frame.File) == hookDir && !strings.HasSuffix(frame.File, "_test.go")
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// collapseInterval is the longest time a query log is held back as a
+// repetition of the previous one.
+const collapseInterval = time.Second
+
+// repetition is a log collapsed with its repetitions.
+type repetition struct {
+	level    zapcore.Level
+	message  string
+	repeated int
+}
+
+// collapser tracks the consecutive logs of the same query shape and level.
+type collapser struct {
+	mu    sync.Mutex
+	key   string
+	since time.Time
+	last  repetition
+	now   func() time.Time
+}
+
+func newCollapser() *collapser {
+	return &collapser{now: time.Now}
+}
+
+// collapse records a log and reports whether it repeats the previous one and
+// should be skipped. Otherwise, it returns the repetitions of the previous
+// log to summarize, if any.
+func (c *collapser) collapse(key string, level zapcore.Level, message string) (bool, *repetition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	key = level.String() + " " + key
+	if key == c.key && now.Sub(c.since) < collapseInterval {
+		c.last.repeated++
+		return true, nil
+	}
+
+	pending := c.take()
+	c.key = key
+	c.since = now
+	c.last = repetition{level: level, message: message}
+
+	return false, pending
+}
+
+// flush returns the pending repetitions, if any.
+func (c *collapser) flush() *repetition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.take()
+}
+
+// expire returns the pending repetitions, if any, once the interval of the
+// previous log has elapsed.
+func (c *collapser) expire() *repetition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.now().Sub(c.since) < collapseInterval {
+		return nil
+	}
+
+	return c.take()
+}
+
+func (c *collapser) take() *repetition {
+	if c.last.repeated == 0 {
+		return nil
+	}
+
+	pending := c.last
+	c.last.repeated = 0
+
+	return &pending
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+// explainKey marks the context of the EXPLAIN queries run by the hook, so
+// that they are not explained in turn.
+type explainKey struct{}
+
+// explainable reports whether the query is a single read statement, which
+// EXPLAIN can be run for without running anything else.
+func explainable(query string) bool {
+	tokens := tokenize(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if len(tokens) == 0 {
+		return false
+	}
+	for _, t := range tokens {
+		if t.text == ";" {
+			return false
+		}
+	}
+
+	switch strings.ToUpper(tokens[0].text) {
+	case "SELECT", "WITH", "VALUES", "TABLE", "(":
+		return isReadQuery(query)
+	default:
+		return false
+	}
+}
+
+// explain runs EXPLAIN for the query, on its connection or transaction when
+// known, and returns the plan, one line per row.
+func (h *QueryHook) explain(ctx context.Context, event *bun.QueryEvent) (string, bool) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var conn bun.IConn = h.explainDB
+	if q, ok := event.IQuery.(interface{ GetConn() bun.IConn }); ok && q.GetConn() != nil {
+		conn = q.GetConn()
+	}
+
+	rows, err := conn.QueryContext(context.WithValue(ctx, explainKey{}, true), "EXPLAIN "+event.Query)
+	if err != nil {
+		return "", false
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", false
+		}
+		lines = append(lines, line)
+	}
+	if rows.Err() != nil {
+		return "", false
+	}
+
+	return strings.Join(lines, "\n"), true
+}
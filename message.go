@@ -0,0 +1,40 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// MessageData is the data available to the message template,
+// see WithMessageTemplate.
+type MessageData struct {
+	// Query is the logged query.
+	Query string
+	// Duration is the duration of the query, rounded to the precision.
+	Duration time.Duration
+	// Error is the error of the query, nil on success.
+	Error error
+	// Operation is the operation of the query, e.g. SELECT.
+	Operation string
+}
+
+// renderMessage renders the message template with data, reporting whether it
+// succeeded. The first failure is logged as a warning.
+func (h *QueryHook) renderMessage(data MessageData) (string, bool) {
+	err := h.messageTmplErr
+	if err == nil {
+		var b strings.Builder
+		if err = h.messageTmpl.Execute(&b, data); err == nil {
+			return b.String(), true
+		}
+	}
+
+	h.messageTmplWarn.Do(func() {
+		h.log(zapcore.WarnLevel, "message template failed, using the default message", zap.Error(err))
+	})
+
+	return "", false
+}
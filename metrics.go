@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+)
+
+// queryMetrics holds the OpenTelemetry instruments recorded by the hook.
+type queryMetrics struct {
+	duration   syncfloat64.Histogram
+	operations syncint64.Counter
+}
+
+// newQueryMetrics creates the instruments once for the given meter, their
+// names prefixed with namespace when set.
+// Creation errors are reported to the global OpenTelemetry error handler.
+func newQueryMetrics(meter metric.Meter, namespace string) *queryMetrics {
+	duration, err := meter.SyncFloat64().Histogram(
+		metricName(namespace, "db.client.operation.duration"),
+		instrument.WithUnit(unit.Unit("s")),
+		instrument.WithDescription("Duration of database client operations."),
+	)
+	if err != nil {
+		otel.Handle(err)
+		return nil
+	}
+
+	operations, err := meter.SyncInt64().Counter(
+		metricName(namespace, "db.client.operations"),
+		instrument.WithUnit(unit.Dimensionless),
+		instrument.WithDescription("Number of database client operations."),
+	)
+	if err != nil {
+		otel.Handle(err)
+		return nil
+	}
+
+	return &queryMetrics{
+		duration:   duration,
+		operations: operations,
+	}
+}
+
+func (m *queryMetrics) record(ctx context.Context, event *bun.QueryEvent, dur time.Duration, failed bool) {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.operation", event.Operation()),
+		attribute.Bool("error", failed),
+	}
+
+	m.duration.Record(ctx, dur.Seconds(), attrs...)
+	m.operations.Add(ctx, 1, attrs...)
+}
+
+func metricName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "." + name
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.uber.org/zap"
+)
+
+func TestNewQueryHook_Meter(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	hook := NewQueryHook(zap.NewNop(), WithMeter(provider.Meter("zapbun")))
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{
+		Query:     "SELECT * FROM nop",
+		StartTime: time.Now(),
+		Err:       errors.New("boom"),
+	})
+
+	rm, err := reader.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	okAttrs := attribute.NewSet(attribute.String("db.operation", "SELECT"), attribute.Bool("error", false))
+	errAttrs := attribute.NewSet(attribute.String("db.operation", "SELECT"), attribute.Bool("error", true))
+
+	metrics := map[string]metricdata.Aggregation{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m.Data
+	}
+
+	hist, ok := metrics["db.client.operation.duration"].(metricdata.Histogram)
+	require.True(t, ok, "duration histogram recorded")
+	counts := map[attribute.Set]uint64{}
+	for _, dp := range hist.DataPoints {
+		counts[dp.Attributes] = dp.Count
+	}
+	assert.Equal(t, map[attribute.Set]uint64{okAttrs: 1, errAttrs: 1}, counts)
+
+	sum, ok := metrics["db.client.operations"].(metricdata.Sum[int64])
+	require.True(t, ok, "operations counter recorded")
+	totals := map[attribute.Set]int64{}
+	for _, dp := range sum.DataPoints {
+		totals[dp.Attributes] = dp.Value
+	}
+	assert.Equal(t, map[attribute.Set]int64{okAttrs: 1, errAttrs: 1}, totals)
+}
+
+func TestNewQueryHook_MetricsNamespace(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	hook := NewQueryHook(zap.NewNop(), WithMeter(provider.Meter("zapbun")), WithMetricsNamespace("myapp"))
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+
+	rm, err := reader.Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, rm.ScopeMetrics, 1)
+
+	names := []string{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		names = append(names, m.Name)
+	}
+	assert.ElementsMatch(t, []string{"myapp.db.client.operation.duration", "myapp.db.client.operations"}, names)
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// sqlKeywords are the words kept by the obfuscator.
+var sqlKeywords = map[string]struct{}{}
+
+func init() {
+	for _, k := range strings.Fields(`
+		ALL AND ANY AS ASC BETWEEN BY CASE CAST CONFLICT CROSS DEFAULT DELETE
+		DESC DISTINCT DO ELSE END EXCEPT EXISTS FALSE FETCH FOR FROM FULL GROUP
+		HAVING ILIKE IN INNER INSERT INTERSECT INTO IS JOIN LATERAL LEFT LIKE
+		LIMIT NOT NOTHING NULL NULLS OFFSET ON OR ORDER OUTER OVER PARTITION
+		RETURNING RIGHT ROWS SELECT SET SOME TABLE THEN TRUE TRUNCATE UNION
+		UPDATE USING VALUES WHEN WHERE WITH`) {
+		sqlKeywords[k] = struct{}{}
+	}
+}
+
+// obfuscator replaces the identifiers of queries by stable placeholders,
+// t1, t2... for tables and c1, c2... for the other ones, and their literals
+// by ?, keeping their structure.
+type obfuscator struct {
+	mu      sync.Mutex
+	names   map[string]string
+	tables  int
+	columns int
+}
+
+func newObfuscator() *obfuscator {
+	return &obfuscator{names: make(map[string]string)}
+}
+
+func (o *obfuscator) obfuscate(query string) string {
+	tokens := tokenize(query)
+	parts := make([]string, 0, len(tokens))
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	table := false
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch t.kind {
+		case tokenLiteral, tokenPlaceholder:
+			parts = append(parts, "?")
+		case tokenIdent:
+			upper := strings.ToUpper(t.text)
+			if _, ok := sqlKeywords[upper]; ok {
+				parts = append(parts, upper)
+				table = upper == "FROM" || upper == "JOIN" || upper == "INTO" || upper == "UPDATE" || upper == "TABLE"
+				continue
+			}
+			// Function calls are kept.
+			if !table && i+1 < len(tokens) && tokens[i+1].text == "(" {
+				parts = append(parts, strings.ToLower(t.text)+"(")
+				i++
+				continue
+			}
+			parts = append(parts, o.name(t.text, table))
+			// Schema qualified tables are tables all along.
+			table = table && i+1 < len(tokens) && tokens[i+1].text == "."
+			continue
+		default:
+			parts = append(parts, t.text)
+		}
+		if t.text != "." {
+			table = false
+		}
+	}
+
+	return joinTokens(parts)
+}
+
+// name returns the placeholder of the identifier, creating it if needed.
+func (o *obfuscator) name(ident string, table bool) string {
+	key := "c:" + strings.ToLower(ident)
+	if table {
+		key = "t:" + strings.ToLower(ident)
+	}
+
+	if name, ok := o.names[key]; ok {
+		return name
+	}
+
+	var name string
+	if table {
+		o.tables++
+		name = fmt.Sprintf("t%d", o.tables)
+	} else {
+		o.columns++
+		name = fmt.Sprintf("c%d", o.columns)
+	}
+	o.names[key] = name
+
+	return name
+}
+
+// joinTokens joins the tokens with spaces, except around dots, after opening
+// parentheses and before closing parentheses, commas and semicolons.
+func joinTokens(parts []string) string {
+	var b strings.Builder
+
+	for i, p := range parts {
+		if i > 0 {
+			prev := parts[i-1]
+			if !strings.HasSuffix(prev, "(") && prev != "." && p != ")" && p != "," && p != ";" && p != "." {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(p)
+	}
+
+	return b.String()
+}
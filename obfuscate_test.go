@@ -0,0 +1,37 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObfuscator(t *testing.T) {
+	o := newObfuscator()
+
+	cases := []struct {
+		description string
+		query       string
+		expected    string
+	}{
+		{
+			description: "Identifiers and literals obfuscated",
+			query:       `SELECT "u"."id", "u"."email" FROM "users" AS "u" WHERE ("u"."email" = 'alice@example.com') LIMIT 1`,
+			expected:    "SELECT c1.c2, c1.c3 FROM t1 AS c1 WHERE (c1.c3 = ?) LIMIT ?",
+		},
+		{
+			description: "Same identifiers, same placeholders",
+			query:       "INSERT INTO users (id, email) VALUES (1, 'bob@example.com')",
+			expected:    "INSERT INTO t1 (c2, c3) VALUES (?, ?)",
+		},
+		{
+			description: "Schema qualified tables and function calls",
+			query:       "SELECT count(*) FROM public.orders JOIN users ON orders.user_id = users.id",
+			expected:    "SELECT count(*) FROM t2.t3 JOIN t1 ON c4.c5 = c6.c2",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, o.obfuscate(tc.query), tc.description)
+	}
+}
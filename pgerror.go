@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Postgres ErrorResponse field codes.
+// https://www.postgresql.org/docs/current/protocol-error-fields.html
+const (
+	pgFieldCode    = 'C'
+	pgFieldMessage = 'M'
+	pgFieldDetail  = 'D'
+	pgFieldHint    = 'H'
+	pgFieldWhere   = 'W'
+)
+
+// fieldError is implemented by errors exposing Postgres ErrorResponse
+// fields, such as pgdriver.Error.
+type fieldError interface {
+	error
+	Field(k byte) string
+}
+
+// pgError returns the Postgres error wrapped in err, if any.
+func pgError(err error) (fieldError, bool) {
+	var pgErr fieldError
+	if errors.As(err, &pgErr) {
+		return pgErr, true
+	}
+
+	return nil, false
+}
+
+// pgDetailFields returns the non-empty code, detail, hint and context of the
+// Postgres error as fields.
+func pgDetailFields(pgErr fieldError) []zap.Field {
+	var fields []zap.Field
+
+	for _, f := range []struct {
+		key  string
+		code byte
+	}{
+		{"db.error.code", pgFieldCode},
+		{"db.error.detail", pgFieldDetail},
+		{"db.error.hint", pgFieldHint},
+		{"db.error.context", pgFieldWhere},
+	} {
+		if v := pgErr.Field(f.code); v != "" {
+			fields = append(fields, zap.String(f.key, v))
+		}
+	}
+
+	return fields
+}
+
+// pgErrorObject marshals the non-empty code, message, detail, hint and
+// context of a Postgres error as an object.
+type pgErrorObject struct {
+	err fieldError
+}
+
+func (o pgErrorObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, f := range []struct {
+		key  string
+		code byte
+	}{
+		{"code", pgFieldCode},
+		{"message", pgFieldMessage},
+		{"detail", pgFieldDetail},
+		{"hint", pgFieldHint},
+		{"where", pgFieldWhere},
+	} {
+		if v := o.err.Field(f.code); v != "" {
+			enc.AddString(f.key, v)
+		}
+	}
+
+	return nil
+}
+
+// errorGroup returns a stable key grouping errors by cause: the SQLSTATE for
+// Postgres errors, or a fingerprint of the error type and message, literals
+// excluded, for the others.
+func errorGroup(err error) string {
+	if pgErr, ok := pgError(err); ok && pgErr.Field(pgFieldCode) != "" {
+		return pgErr.Field(pgFieldCode)
+	}
+
+	return Fingerprint(fmt.Sprintf("%T %s", err, err))
+}
+
+// normalizeErrorMessage returns the message of the error, its Postgres
+// primary message when available, with the volatile details replaced so that
+// errors of the same kind share it: quoted names by "%s", quoted values by
+// '%s' and numbers by %d.
+func normalizeErrorMessage(err error) string {
+	msg := err.Error()
+	if pgErr, ok := pgError(err); ok && pgErr.Field(pgFieldMessage) != "" {
+		msg = pgErr.Field(pgFieldMessage)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+
+		switch {
+		case c == '"' || c == '\'':
+			b.WriteByte(c)
+			b.WriteString("%s")
+			b.WriteByte(c)
+			i = closingQuote(msg, i) - 1
+		case c >= '0' && c <= '9' && (i == 0 || !isIdentPart(msg[i-1])):
+			end := i
+			for end < len(msg) && isIdentPart(msg[end]) {
+				end++
+			}
+			if strings.Trim(msg[i:end], "0123456789") != "" {
+				b.WriteString(msg[i:end])
+			} else {
+				b.WriteString("%d")
+			}
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// errorSampler counts errors per query fingerprint to tell which occurrences
+// should be logged in detail.
+type errorSampler struct {
+	mu     sync.Mutex
+	every  int
+	counts map[string]int
+}
+
+func newErrorSampler(every int) *errorSampler {
+	return &errorSampler{
+		every:  every,
+		counts: make(map[string]int),
+	}
+}
+
+// sample records an error for the fingerprint and reports whether it is an
+// Nth occurrence.
+func (s *errorSampler) sample(fingerprint string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[fingerprint]++
+
+	return s.counts[fingerprint]%s.every == 0
+}
@@ -3,29 +3,218 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
 )
 
 type QueryHook struct {
 	errorFieldName  string
+	fixedMessage    string
+	queryEncoder    func(sql string) zapcore.Field
+	successMessage  func(query string) string
+	messageTmpl     *template.Template
+	messageTmplErr  error
+	messageTmplWarn sync.Once
+	messageSuffix   string
+	structuredQuery bool
+	fieldOrder      map[string]int
+	minimalSuccess  bool
+	resultErrorKey  string
+	inTxKey         string
+	accessKindKey   string
+	operationKey    string
+	returnedRowsKey string
 	precision       time.Duration
+	rounding        RoundingMode
+	durationValKey  string
+	durationUnitKey string
+	durationObjKey  string
+	slowDurKey      string
+	slowDurOver     time.Duration
+	budgetKey       string
+	poolWaitKey     string
 	logger          *zap.Logger
+	name            string
 	enabled         bool
 	verbose         bool
+	adaptive        *adaptiveVerbose
+	throttles       map[string]*rate.Limiter
+	suppressProbes  bool
+	tableFilter     map[string]struct{}
+	fingerprints    map[string]struct{}
+	samplingRatio   float64
+	samplingSeed    *int64
+	sampler         *ratioSampler
 	durationAsField bool
+	nativeDuration  bool
 	errorAsField    bool
+	errorBoth       bool
+	pgErrorContext  bool
+	errorSampler    *errorSampler
+	errorGroupKey   string
+	normErrorMsg    bool
+	pgErrorKey      string
+	noticeLevel     *zapcore.Level
+	errorFields     []zap.Field
+	errorCtxFields  []func(ctx context.Context) []zap.Field
+	errSummaryKey   string
+	errSummaryLen   int
+	ctxErrPriority  bool
+	minErrorDur     time.Duration
 	duration        bool
+	trimComments    bool
+	unquoteIdents   bool
+	stripPrefixes   []string
+	replacer        *strings.Replacer
+	obfuscator      *obfuscator
+	pgStatQuery     bool
+	rawQueryKey     string
+	normQueryKey    string
+	redactedKey     string
+	redactQuery     func(query string) string
+	commentKey      string
+	queryArgs       bool
+	argsJSONKey     string
+	expandOnError   bool
+	errorStartKey   string
+	redactColumns   map[string]struct{}
+	redactValues    *regexp.Regexp
+	argMaxLen       int
+	contextFields   map[interface{}]string
+	correlation     func(ctx context.Context) zap.Field
+	eventFields     []func(event *bun.QueryEvent) []zap.Field
+	opNameKey       string
+	isolationKey    string
+	attemptKey      string
+	attempt         func(ctx context.Context) int
+	sequenceKey     string
+	sequence        *uint64
+	callerKey       string
+	callerSkip      int
+	argMismatchWarn bool
+	slowThreshold   time.Duration
+	slowOnly        *time.Duration
+	explainDB       *bun.DB
+	explainAfter    time.Duration
+	onSlowQuery     func(event *bun.QueryEvent, dur time.Duration)
+	onTimeout       func(event *bun.QueryEvent, dur time.Duration)
+	errorBurst      *errorBurst
+	firstOnly       bool
+	forgetAfter     time.Duration
+	shapes          *shapeTracker
+	onErrorBurst    func()
+	cloneEvent      bool
+	observers       []func(ctx context.Context, event *bun.QueryEvent, dur time.Duration)
+	middlewares     []func(next func())
+	sink            func(level zapcore.Level, message string, fields []zap.Field)
+	collapser       *collapser
+	beforeQuery     bool
+	beforeLevel     zapcore.Level
+	structStart     bool
+	queryIDs        *uint64
 	queryLevel      zapcore.Level
 	errorLevel      zapcore.Level
+	sqlStateLevels  map[string]zapcore.Level
+	zeroRowsWrite   bool
+	zeroRowsLevel   zapcore.Level
+	largeRows       int64
+	largeResultLvl  *zapcore.Level
+	ddlLevel        *zapcore.Level
+	unqualifiedLvl  *zapcore.Level
+	rowsTiers       []RowsTier
+	maxLevel        *zapcore.Level
+	levelOverride   func(defaultLevel zapcore.Level) zapcore.Level
+	levelGate       zapcore.LevelEnabler
+	meter           metric.Meter
+	metricsPrefix   string
+	queryLengths    *histogram
+	stats           *queryStats
+	group           *HookGroup
+	logSlots        chan struct{}
+	flushInterval   time.Duration
+	onStatsFlush    func(Stats)
+	summary         *windowSummary
+	summaryEvery    time.Duration
+	summaryLevel    zapcore.Level
+	summaryOnly     bool
+	metrics         *queryMetrics
+	annotateSpan    bool
+
+	onSyncError   func(error)
+	strictOptions bool
+
+	mu        sync.RWMutex
+	closers   []func() error
+	closeOnce sync.Once
+	closeErr  error
 }
 
 type Option func(*QueryHook)
 
+// RowsTier maps a minimum number of affected rows to a level,
+// see WithRowsAffectedLevels.
+type RowsTier struct {
+	MinRows int64
+	Level   zapcore.Level
+}
+
+// RoundingMode tells how durations are rounded to the precision,
+// see WithDurationRounding.
+type RoundingMode int
+
+const (
+	// RoundingNearest rounds durations to the nearest multiple of the
+	// precision, halfway values away from zero.
+	RoundingNearest RoundingMode = iota
+	// RoundingFloor rounds durations down.
+	RoundingFloor
+	// RoundingCeil rounds durations up, to never understate them.
+	RoundingCeil
+)
+
+// Preset is a coherent group of options, see WithPreset.
+type Preset int
+
+const (
+	// PresetMinimal logs failed queries only, without duration.
+	PresetMinimal Preset = iota
+	// PresetVerbose logs all queries, with the duration in the message.
+	PresetVerbose
+	// PresetStructured logs all queries with a fixed message, the query,
+	// duration and error being fields.
+	PresetStructured
+)
+
+const (
+	redactedValue   = "[REDACTED]"
+	unencodableArgs = "[UNENCODABLE]"
+)
+
+// WithHookName configures the hook to add a hook field with the given name to
+// all its logs, to tell apart the logs of several hooks.
+func WithHookName(name string) Option {
+	return func(h *QueryHook) {
+		h.name = name
+	}
+}
+
 // WithEnabled enables/disables the hook.
 func WithEnabled(on bool) Option {
 	return func(h *QueryHook) {
@@ -33,6 +222,36 @@ func WithEnabled(on bool) Option {
 	}
 }
 
+// WithPreset configures the hook with a group of options.
+// Options passed after the preset override it.
+func WithPreset(preset Preset) Option {
+	return func(h *QueryHook) {
+		switch preset {
+		case PresetMinimal:
+			h.verbose = false
+			h.duration = false
+			h.durationAsField = false
+			h.errorAsField = false
+			h.errorBoth = false
+			h.fixedMessage = ""
+		case PresetVerbose:
+			h.verbose = true
+			h.duration = true
+			h.durationAsField = false
+			h.errorAsField = false
+			h.errorBoth = false
+			h.fixedMessage = ""
+		case PresetStructured:
+			h.verbose = true
+			h.duration = true
+			h.durationAsField = true
+			h.errorAsField = true
+			h.errorBoth = false
+			h.fixedMessage = "db.query"
+		}
+	}
+}
+
 // WithVerbose configures the hook to log all queries
 // (by default, only failed queries are logged).
 func WithVerbose(on bool) Option {
@@ -41,6 +260,135 @@ func WithVerbose(on bool) Option {
 	}
 }
 
+// WithSlowOnly configures the hook to log the successful queries lasting at
+// least threshold, and only those, whatever WithVerbose. Failed queries are
+// logged as usual.
+func WithSlowOnly(threshold time.Duration) Option {
+	return func(h *QueryHook) {
+		h.slowOnly = &threshold
+	}
+}
+
+// WithExplainOnSlow configures the hook to run EXPLAIN for the logged
+// successful read queries lasting at least threshold, and to log the plan as
+// a plan field. EXPLAIN runs on the connection or transaction of builder
+// queries, on db otherwise, and only for single statements. The plan is left
+// out when EXPLAIN fails. The EXPLAIN queries are not explained in turn.
+func WithExplainOnSlow(threshold time.Duration, db *bun.DB) Option {
+	return func(h *QueryHook) {
+		h.explainAfter = threshold
+		h.explainDB = db
+	}
+}
+
+// WithBeforeQuery configures the hook to also log queries when they start.
+// The start line logs the query as the completion one, redacted and
+// transformed as configured, without its arguments.
+func WithBeforeQuery(on bool) Option {
+	return func(h *QueryHook) {
+		h.beforeQuery = on
+	}
+}
+
+// WithBeforeQueryLevel configures the level of the query start line,
+// independently of the completion levels.
+func WithBeforeQueryLevel(level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.beforeLevel = level
+	}
+}
+
+type queryIDStashKey struct{}
+
+// WithStructuredBeforeQuery configures the hook to log the query start line,
+// when enabled, with a fixed message and the query, a query_id and the
+// db.operation as fields. The query_id is also logged with the completion
+// line, to match both.
+func WithStructuredBeforeQuery() Option {
+	return func(h *QueryHook) {
+		h.structStart = true
+		h.queryIDs = new(uint64)
+	}
+}
+
+// WithAdaptiveVerbose configures the hook to turn verbose logging on while
+// the error rate of the queries, measured over the given window, exceeds
+// errorRateThreshold (between 0 and 1).
+func WithAdaptiveVerbose(errorRateThreshold float64, window time.Duration) Option {
+	return func(h *QueryHook) {
+		h.adaptive = newAdaptiveVerbose(errorRateThreshold, window)
+	}
+}
+
+// WithThrottlePerOperation configures the hook to limit, per operation
+// (SELECT, INSERT...), the rate of successful queries logged. The queries
+// filtered out by the level do not count. Failed queries are always logged.
+func WithThrottlePerOperation(limits map[string]rate.Limit) Option {
+	return func(h *QueryHook) {
+		h.throttles = make(map[string]*rate.Limiter, len(limits))
+		for op, limit := range limits {
+			burst := int(math.Ceil(float64(limit)))
+			if burst < 1 {
+				burst = 1
+			}
+			h.throttles[strings.ToUpper(op)] = rate.NewLimiter(limit, burst)
+		}
+	}
+}
+
+// WithSuppressProbes configures the hook not to log successful health check
+// probes, such as SELECT 1. They are still counted in the stats.
+func WithSuppressProbes() Option {
+	return func(h *QueryHook) {
+		h.suppressProbes = true
+	}
+}
+
+// WithTableFilter configures the hook to only log the successful queries
+// referencing one of the given tables. Failed queries are always logged.
+// Names are matched case-insensitively, with or without schema.
+func WithTableFilter(tables ...string) Option {
+	return func(h *QueryHook) {
+		if h.tableFilter == nil {
+			h.tableFilter = make(map[string]struct{}, len(tables))
+		}
+		for _, table := range tables {
+			h.tableFilter[strings.ToLower(table)] = struct{}{}
+		}
+	}
+}
+
+// WithFingerprintAllowlist configures the hook to only log the successful
+// queries whose fingerprint (see Fingerprint) is one of the given ones.
+// Failed queries are always logged.
+func WithFingerprintAllowlist(fingerprints ...string) Option {
+	return func(h *QueryHook) {
+		if h.fingerprints == nil {
+			h.fingerprints = make(map[string]struct{}, len(fingerprints))
+		}
+		for _, fp := range fingerprints {
+			h.fingerprints[fp] = struct{}{}
+		}
+	}
+}
+
+// WithSampling configures the hook to log only the given ratio, between 0
+// and 1, of the successful queries, picked randomly among the ones enabled by
+// the level. Failed queries are always logged.
+func WithSampling(ratio float64) Option {
+	return func(h *QueryHook) {
+		h.samplingRatio = ratio
+	}
+}
+
+// WithSamplingSeed configures the seed of the random source used by
+// WithSampling, for reproducible sampling decisions.
+func WithSamplingSeed(seed int64) Option {
+	return func(h *QueryHook) {
+		h.samplingSeed = &seed
+	}
+}
+
 // WithDurationAsField configures the hook to set the duration as field,
 // written in the message by default.
 func WithDurationAsField() Option {
@@ -50,109 +398,2099 @@ func WithDurationAsField() Option {
 	}
 }
 
+// WithNativeDurationField configures the hook to log the duration as a
+// native zap duration field, leaving its representation to the encoder
+// (see zapcore.EncoderConfig.EncodeDuration).
+func WithNativeDurationField() Option {
+	return func(h *QueryHook) {
+		h.duration = true
+		h.durationAsField = true
+		h.nativeDuration = true
+	}
+}
+
 // WithDurationPrecision configures the hook to log the duration with
 // the specified precision.
 // e.g. passing time.Millisecond returns a duration in ms.
 func WithDurationPrecision(precision time.Duration) Option {
 	return func(h *QueryHook) {
-		h.durationAsField = true
+		h.precision = precision
 	}
 }
 
-// WithErrorAsField configures the hook to log the error as a field.
-func WithErrorAsField(field string) Option {
+// WithDurationObject configures the hook to log the duration as an object
+// with the given key, holding its value in the precision unit, the unit and a
+// human readable form, e.g. {"value": 12, "unit": "ms", "human": "12ms"}.
+func WithDurationObject(key string) Option {
 	return func(h *QueryHook) {
-		h.errorAsField = true
-		h.errorFieldName = field
+		h.durationObjKey = key
 	}
 }
 
-// WithLevels configures the hook to make proper usage of zap levels.
-func WithLevels(queryLevel, errorLevel zapcore.Level) Option {
+// WithDurationRounding configures how the hook rounds the logged duration to
+// the precision. Durations are rounded to the nearest by default.
+func WithDurationRounding(mode RoundingMode) Option {
 	return func(h *QueryHook) {
-		h.queryLevel = queryLevel
-		h.errorLevel = errorLevel
+		h.rounding = mode
 	}
 }
 
-// WithDuration configures the hook to log the duration.
-func WithDuration() Option {
+// WithDurationValueUnitFields configures the hook to log the duration as a
+// number under valueKey, expressed in the duration precision, along with the
+// precision unit under unitKey.
+// e.g. with millisecond precision: valueKey=12 unitKey=ms.
+func WithDurationValueUnitFields(valueKey, unitKey string) Option {
 	return func(h *QueryHook) {
-		h.duration = true
+		h.durationValKey = valueKey
+		h.durationUnitKey = unitKey
 	}
 }
 
-// NewQueryHook creates a new query hook.
-func NewQueryHook(logger *zap.Logger, opts ...Option) *QueryHook {
-	qh := &QueryHook{
-		errorFieldName:  "error",
-		precision:       time.Millisecond,
-		logger:          logger,
-		enabled:         true,
-		verbose:         false,
-		durationAsField: false,
-		errorAsField:    false,
-		duration:        false,
-		queryLevel:      zapcore.DebugLevel,
-		errorLevel:      zapcore.ErrorLevel,
+// WithQueryBudgetField configures the hook to log, when the query context has
+// a deadline, the time the query had to complete, from its start to the
+// deadline, as a duration field with the given key.
+func WithQueryBudgetField(key string) Option {
+	return func(h *QueryHook) {
+		h.budgetKey = key
 	}
+}
 
-	for _, opt := range opts {
-		opt(qh)
+type poolWaitStashKey struct{}
+
+// WithDurationFieldWhenOver configures the hook to log the duration as a
+// field with the given key only for the queries lasting more than threshold.
+func WithDurationFieldWhenOver(threshold time.Duration, key string) Option {
+	return func(h *QueryHook) {
+		h.slowDurOver = threshold
+		h.slowDurKey = key
 	}
+}
 
-	return qh
+// WithPoolWaitField configures the hook to log the time spent waiting for a
+// connection of the pool, as a duration field with the given key.
+// bun does not tell when the connection is acquired, so the wait is measured
+// as the growth of the pool total wait time during the query: it is exact
+// when no other query waits at the same time, an upper bound otherwise.
+func WithPoolWaitField(key string) Option {
+	return func(h *QueryHook) {
+		h.poolWaitKey = key
+	}
 }
 
-func (h *QueryHook) BeforeQuery(ctx context.Context, _ *bun.QueryEvent) context.Context { return ctx }
+// WithTrimComments configures the hook to strip SQL comments from the
+// logged query.
+func WithTrimComments() Option {
+	return func(h *QueryHook) {
+		h.trimComments = true
+	}
+}
 
-func (h *QueryHook) AfterQuery(_ context.Context, event *bun.QueryEvent) {
-	if !h.enabled {
-		return
+// WithUnquoteIdentifiers configures the hook to remove the quotes around the
+// identifiers of the logged query, leaving string literals untouched. Mixed
+// case identifiers and reserved words keep their quotes.
+func WithUnquoteIdentifiers() Option {
+	return func(h *QueryHook) {
+		h.unquoteIdents = true
 	}
+}
 
-	var level zapcore.Level
-	var err error
+// WithStripPrefixes configures the hook to remove the first matching prefix,
+// compared case-insensitively, from the logged query.
+func WithStripPrefixes(prefixes ...string) Option {
+	return func(h *QueryHook) {
+		h.stripPrefixes = append(h.stripPrefixes, prefixes...)
+	}
+}
 
-	switch event.Err {
-	case nil, sql.ErrNoRows, sql.ErrTxDone:
-		if !h.verbose {
-			return
-		}
-		level = h.queryLevel
-		err = nil
-	default:
-		level = h.errorLevel
-		err = event.Err
+// WithReplacer configures the hook to apply r to the logged query.
+func WithReplacer(r *strings.Replacer) Option {
+	return func(h *QueryHook) {
+		h.replacer = r
 	}
+}
 
-	now := time.Now()
-	dur := now.Sub(event.StartTime)
+// WithObfuscateIdentifiers configures the hook to log the query with its
+// identifiers replaced by placeholders, stable for the lifetime of the hook,
+// and its literals by ?, to share query shapes without leaking the schema or
+// data, in the start lines as well. The query arguments are not logged.
+// Fields logging the raw query, such as the expanded query on errors, are not
+// obfuscated.
+func WithObfuscateIdentifiers() Option {
+	return func(h *QueryHook) {
+		h.obfuscator = newObfuscator()
+	}
+}
 
-	message := event.Query
-	fields := []zap.Field{}
+// WithPgStatNormalization configures the hook to log the query normalized as
+// by pg_stat_statements, constants replaced by $N parameters, as a
+// normalized_query field, to cross-reference both.
+func WithPgStatNormalization() Option {
+	return func(h *QueryHook) {
+		h.pgStatQuery = true
+	}
+}
 
-	if h.duration && h.durationAsField {
-		fields = append(fields, zap.Field{
-			Key:       "duration",
-			Type:      zapcore.StringerType,
-			Interface: dur.Round(h.precision),
-		})
-	} else if h.duration {
-		message = fmt.Sprintf("duration: %s %s", dur.Round(h.precision), message)
+// WithRawAndNormalizedFields configures the hook to log the query as executed
+// under rawKey, and normalized under normKey: literals replaced by ?,
+// comments removed and identifiers lowercased, as used by Fingerprint.
+func WithRawAndNormalizedFields(rawKey, normKey string) Option {
+	return func(h *QueryHook) {
+		h.rawQueryKey = rawKey
+		h.normQueryKey = normKey
 	}
+}
 
-	if err != nil {
-		if h.errorAsField {
-			fields = append(fields, zap.Field{
-				Key:       h.errorFieldName,
-				Type:      zapcore.ErrorType,
-				Interface: err,
-			})
-		} else {
-			message = fmt.Sprintf("%s error: %s", message, err)
+// WithRedactedQueryField configures the hook to log under key the query as
+// executed redacted by fn, e.g. to share it safely while the query itself is
+// left out with WithFixedMessage and a WithQueryFieldEncoder returning
+// zap.Skip.
+func WithRedactedQueryField(key string, fn func(query string) string) Option {
+	return func(h *QueryHook) {
+		h.redactedKey = key
+		h.redactQuery = fn
+	}
+}
+
+// WithLeadingCommentField configures the hook to log under key the comment
+// the query starts with, such as /* app:billing */, verbatim. Queries without
+// a leading comment are logged without the field.
+func WithLeadingCommentField(key string) Option {
+	return func(h *QueryHook) {
+		h.commentKey = key
+	}
+}
+
+// WithQueryArgs configures the hook to log the query template along with
+// its arguments as a field, instead of the formatted query.
+// Only raw queries (db.ExecContext, db.QueryContext...) have their arguments
+// apart: bun inlines the values of builder queries (db.NewInsert()...), which
+// are logged formatted, without args field.
+func WithQueryArgs() Option {
+	return func(h *QueryHook) {
+		h.queryArgs = true
+	}
+}
+
+// WithArgsJSONField configures the hook to log the query arguments, once
+// redacted, as a single JSON-encoded string field under the given key.
+// Like WithQueryArgs, it has no effect on builder queries.
+func WithArgsJSONField(key string) Option {
+	return func(h *QueryHook) {
+		h.queryArgs = true
+		h.argsJSONKey = key
+	}
+}
+
+// WithExpandArgsOnError configures the hook to log, on failed queries, the
+// query with its arguments expanded as an expanded_query field, to reproduce
+// the failure. The arguments are redacted and truncated as logged; the field
+// is left out when they cannot be expanded again, i.e. without event.DB.
+func WithExpandArgsOnError() Option {
+	return func(h *QueryHook) {
+		h.expandOnError = true
+	}
+}
+
+// WithErrorStartTime configures the hook to log, on failed queries, the start
+// time of the query as a field with the given key, to correlate the failure
+// with other events.
+func WithErrorStartTime(key string) Option {
+	return func(h *QueryHook) {
+		h.errorStartKey = key
+	}
+}
+
+// WithRedactArgsForColumns configures the hook to log the query arguments,
+// redacting the ones bound to the given columns.
+// Columns are inferred from the query on a best effort basis: arguments
+// that cannot be matched to a column are logged as is.
+// Builder queries are logged unredacted, as their values are inlined in the
+// query by bun, see WithQueryArgs.
+func WithRedactArgsForColumns(names ...string) Option {
+	return func(h *QueryHook) {
+		h.queryArgs = true
+		if h.redactColumns == nil {
+			h.redactColumns = make(map[string]struct{}, len(names))
+		}
+		for _, name := range names {
+			h.redactColumns[strings.ToLower(name)] = struct{}{}
 		}
 	}
+}
 
-	h.logger.Log(level, message, fields...)
+// WithRedactArgValues configures the hook to log the query arguments,
+// redacting the string ones matching re, e.g. tokens or emails.
+// As with WithRedactArgsForColumns, the values inlined in builder queries are
+// not redacted.
+func WithRedactArgValues(re *regexp.Regexp) Option {
+	return func(h *QueryHook) {
+		h.queryArgs = true
+		h.redactValues = re
+	}
+}
+
+// WithArgsMaxValueLength configures the hook to truncate the string and
+// []byte query arguments logged with WithQueryArgs to n runes or bytes,
+// followed by an ellipsis. Other arguments are logged unchanged, as are the
+// values inlined in builder queries.
+func WithArgsMaxValueLength(n int) Option {
+	return func(h *QueryHook) {
+		h.argMaxLen = n
+	}
+}
+
+// WithContextValueFields configures the hook to log the values found in the
+// query context for the given keys, under the mapped field names.
+// Keys missing from the context are omitted.
+func WithContextValueFields(mapping map[interface{}]string) Option {
+	return func(h *QueryHook) {
+		h.contextFields = mapping
+	}
+}
+
+// WithCorrelationField configures the hook to log the field returned by fn
+// for the query context, unless it is a zap.Skip field.
+func WithCorrelationField(fn func(ctx context.Context) zap.Field) Option {
+	return func(h *QueryHook) {
+		h.correlation = fn
+	}
+}
+
+type operationNameKey struct{}
+
+// WithOperationName returns a copy of ctx tagged with the logical name of the
+// operation its queries belong to, e.g. GetUserByEmail,
+// see WithOperationNameField.
+func WithOperationName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, operationNameKey{}, name)
+}
+
+// WithOperationNameField configures the hook to log the operation name tagged
+// in the query context, see WithOperationName, as a field with the given key.
+// Queries without name are logged without the field.
+func WithOperationNameField(key string) Option {
+	return func(h *QueryHook) {
+		h.opNameKey = key
+	}
+}
+
+type isolationLevelKey struct{}
+
+// WithIsolationLevel returns a copy of ctx tagged with the isolation level of
+// the transaction its queries run in, as passed to BeginTx, see
+// WithIsolationLevelField. bun does not expose the level of a transaction.
+func WithIsolationLevel(ctx context.Context, level sql.IsolationLevel) context.Context {
+	return context.WithValue(ctx, isolationLevelKey{}, level)
+}
+
+// WithIsolationLevelField configures the hook to log the isolation level
+// tagged in the query context, see WithIsolationLevel, as a field with the
+// given key. Queries known not to run in a transaction, or tagged with the
+// default level, are logged without the field.
+func WithIsolationLevelField(key string) Option {
+	return func(h *QueryHook) {
+		h.isolationKey = key
+	}
+}
+
+// WithAttemptField configures the hook to log the attempt number returned by
+// fn for the query context, as set by a retry wrapper, as a field with the
+// given key. Attempt numbers lower than 1 are not logged.
+func WithAttemptField(key string, fn func(ctx context.Context) int) Option {
+	return func(h *QueryHook) {
+		h.attemptKey = key
+		h.attempt = fn
+	}
+}
+
+// WithSequenceField configures the hook to log a number increasing with each
+// log of the hook, as a field with the given key, to order the logs when
+// their timestamps collide.
+func WithSequenceField(key string) Option {
+	return func(h *QueryHook) {
+		h.sequenceKey = key
+		h.sequence = new(uint64)
+	}
+}
+
+// WithCallerField configures the hook to log under key the file:line the
+// query was issued from, i.e. the first frame outside bun and the hook, skip
+// being the number of frames to ascend above it, e.g. to skip a repository
+// helper. It is cheaper than a stack.
+func WithCallerField(key string, skip int) Option {
+	return func(h *QueryHook) {
+		h.callerKey = key
+		h.callerSkip = skip
+	}
+}
+
+// WithEventFields configures the hook to log the fields returned by fn for
+// the query event.
+func WithEventFields(fn func(event *bun.QueryEvent) []zap.Field) Option {
+	return func(h *QueryHook) {
+		h.eventFields = append(h.eventFields, fn)
+	}
+}
+
+// WithArgMismatchWarn configures the hook to log a warning whenever the
+// number of placeholders of a query differs from the number of arguments,
+// including on successful queries. The warning goes through the level
+// override, the maximum level, the atomic level and the sink like the queries.
+func WithArgMismatchWarn() Option {
+	return func(h *QueryHook) {
+		h.argMismatchWarn = true
+	}
+}
+
+// WithSlowQueryCallback configures the hook to call fn whenever a successful
+// query takes longer than threshold, whether or not the query is logged.
+func WithSlowQueryCallback(threshold time.Duration, fn func(event *bun.QueryEvent, dur time.Duration)) Option {
+	return func(h *QueryHook) {
+		h.slowThreshold = threshold
+		h.onSlowQuery = fn
+	}
+}
+
+// WithTimeoutCallback configures the hook to call fn whenever a query fails
+// with context.DeadlineExceeded, whether or not the query is logged.
+func WithTimeoutCallback(fn func(event *bun.QueryEvent, dur time.Duration)) Option {
+	return func(h *QueryHook) {
+		h.onTimeout = fn
+	}
+}
+
+// WithErrorBurstCallback configures the hook to call fn when more than count
+// queries fail within a window, once per window, e.g. to trip a circuit
+// breaker.
+func WithErrorBurstCallback(count int, window time.Duration, fn func()) Option {
+	return func(h *QueryHook) {
+		h.errorBurst = newErrorBurst(count, window)
+		h.onErrorBurst = fn
+	}
+}
+
+// WithObserver configures the hook to call fn for every query, before any
+// filtering or level decision. It may be used several times.
+func WithObserver(fn func(ctx context.Context, event *bun.QueryEvent, dur time.Duration)) Option {
+	return func(h *QueryHook) {
+		h.observers = append(h.observers, fn)
+	}
+}
+
+// WithMiddleware configures the hook to call fn around the logging of each
+// query, fn calling next to log, or not to skip the log. Middlewares are
+// called in the order they are configured, the first one being the outermost.
+func WithMiddleware(fn func(next func())) Option {
+	return func(h *QueryHook) {
+		h.middlewares = append(h.middlewares, fn)
+	}
+}
+
+// WithSink configures the hook to pass everything it logs to fn instead of
+// logging it, for custom delivery: the query, start, summary and notice logs
+// and the warnings, with the hook field of WithHookName first. The logger
+// levels still tell which entries are logged.
+func WithSink(fn func(level zapcore.Level, message string, fields []zap.Field)) Option {
+	return func(h *QueryHook) {
+		h.sink = fn
+	}
+}
+
+// WithCollapseConsecutive configures the hook to log once the same query
+// shape logged repeatedly in a row at the same level, then the number of
+// repetitions as a repeated field, when another query is logged, within a
+// couple of seconds, or when the hook is closed, see QueryHook.Close.
+func WithCollapseConsecutive() Option {
+	return func(h *QueryHook) {
+		h.collapser = newCollapser()
+	}
+}
+
+// WithCloneEvent configures the hook to pass callbacks a copy of the query
+// event, which they can safely retain or use asynchronously.
+func WithCloneEvent() Option {
+	return func(h *QueryHook) {
+		h.cloneEvent = true
+	}
+}
+
+// WithErrorAsField configures the hook to log the error as a field.
+func WithErrorAsField(field string) Option {
+	return func(h *QueryHook) {
+		h.errorAsField = true
+		h.errorFieldName = field
+	}
+}
+
+// WithErrorBoth configures the hook to log the error as a field, while still
+// appending it to the message.
+func WithErrorBoth(field string) Option {
+	return func(h *QueryHook) {
+		h.errorAsField = true
+		h.errorBoth = true
+		h.errorFieldName = field
+	}
+}
+
+// WithResultErrorField configures the hook to log, under the given key,
+// any error returned by RowsAffected or LastInsertId on successful queries.
+// The log level is left unchanged.
+// bun passes the hook the driver result of raw ExecContext queries only.
+// Builder queries scanning rows, such as inserts with RETURNING, including
+// the one bun adds for autoincrement primary keys on Postgres, get a result
+// always failing LastInsertId, so they are logged with the field. Other
+// builder queries, such as NewInsert().Exec without RETURNING, are logged
+// without it.
+func WithResultErrorField(key string) Option {
+	return func(h *QueryHook) {
+		h.resultErrorKey = key
+	}
+}
+
+// WithFixedMessage configures the hook to log every query with the given
+// constant message, moving the query, duration and error into fields.
+func WithFixedMessage(msg string) Option {
+	return func(h *QueryHook) {
+		h.fixedMessage = msg
+	}
+}
+
+// WithQueryFieldEncoder configures the hook to build the query field, logged
+// along with a fixed message, with fn.
+func WithQueryFieldEncoder(fn func(sql string) zapcore.Field) Option {
+	return func(h *QueryHook) {
+		h.queryEncoder = fn
+	}
+}
+
+// WithSuccessMessage configures the hook to build the message of successful
+// queries from the query with fn. Failed queries are not affected.
+func WithSuccessMessage(fn func(query string) string) Option {
+	return func(h *QueryHook) {
+		h.successMessage = fn
+	}
+}
+
+// WithMessageTemplate configures the hook to render the log messages with the
+// given text/template, executed with MessageData. The duration and error are
+// then only in the message if the template has them. On failure, the default
+// message is used and a warning is logged once.
+func WithMessageTemplate(tmpl string) Option {
+	return func(h *QueryHook) {
+		h.messageTmpl, h.messageTmplErr = template.New("message").Parse(tmpl)
+	}
+}
+
+// WithMessageSuffix configures the hook to append suffix to the message,
+// after the duration and error. It has no effect with a fixed message.
+func WithMessageSuffix(suffix string) Option {
+	return func(h *QueryHook) {
+		h.messageSuffix = suffix
+	}
+}
+
+// WithStructuredQueryFields configures the hook to log the query operation,
+// main table and statement as the db.operation, db.sql.table and
+// db.statement fields.
+func WithStructuredQueryFields() Option {
+	return func(h *QueryHook) {
+		h.structuredQuery = true
+	}
+}
+
+// WithFieldOrder configures the hook to log the given field keys first, in
+// that order, followed by the other fields.
+func WithFieldOrder(keys ...string) Option {
+	return func(h *QueryHook) {
+		h.fieldOrder = make(map[string]int, len(keys))
+		for i, key := range keys {
+			h.fieldOrder[key] = i
+		}
+	}
+}
+
+// WithInTransactionField configures the hook to log, under the given key,
+// whether the query ran within a transaction.
+// The transaction can only be detected for queries built with the query
+// builder (e.g. tx.NewSelect()): the field is omitted for raw queries, as bun
+// does not tell their connection, including tx.ExecContext, tx.QueryContext
+// and the BEGIN, COMMIT and ROLLBACK of the transactions.
+func WithInTransactionField(key string) Option {
+	return func(h *QueryHook) {
+		h.inTxKey = key
+	}
+}
+
+// WithPgErrorContext configures the hook to log the server-side context of
+// Postgres errors (e.g. the failing PL/pgSQL frame) as a db.error.context
+// field, when present.
+func WithPgErrorContext() Option {
+	return func(h *QueryHook) {
+		h.pgErrorContext = true
+	}
+}
+
+// WithContextErrorPriority configures the hook to log, when the query context
+// is done, the context error instead of the query one, which is then logged
+// as a db_error field.
+func WithContextErrorPriority() Option {
+	return func(h *QueryHook) {
+		h.ctxErrPriority = true
+	}
+}
+
+// WithSuppressFastErrors configures the hook not to log the queries failing
+// in less than minDuration, such as when the database is unreachable.
+// They are still counted in the stats and metrics.
+func WithSuppressFastErrors(minDuration time.Duration) Option {
+	return func(h *QueryHook) {
+		h.minErrorDur = minDuration
+	}
+}
+
+// WithErrorFields configures the hook to add the given fields to the logs of
+// failed queries only.
+func WithErrorFields(fields ...zap.Field) Option {
+	return func(h *QueryHook) {
+		h.errorFields = append(h.errorFields, fields...)
+	}
+}
+
+// WithErrorContextFields configures the hook to add the fields returned by fn
+// for the query context, e.g. the user or tenant, to the logs of failed
+// queries only.
+func WithErrorContextFields(fn func(ctx context.Context) []zap.Field) Option {
+	return func(h *QueryHook) {
+		h.errorCtxFields = append(h.errorCtxFields, fn)
+	}
+}
+
+// WithErrorSummaryField configures the hook to log, on failed queries, the
+// error on a single line and cut to maxLen characters, as a field with the
+// given key, along with the full error. A maxLen of 0 or less does not cut it.
+func WithErrorSummaryField(key string, maxLen int) Option {
+	return func(h *QueryHook) {
+		h.errSummaryKey = key
+		h.errSummaryLen = maxLen
+	}
+}
+
+// WithErrorGroupField configures the hook to log, on failed queries, a key
+// grouping the errors by cause under the given key: the SQLSTATE for Postgres
+// errors, or a fingerprint of the error type and message for the others.
+func WithErrorGroupField(key string) Option {
+	return func(h *QueryHook) {
+		h.errorGroupKey = key
+	}
+}
+
+// WithNormalizeErrorMessage configures the hook to log, on failed queries, the
+// error message without its volatile details, such as relation names or
+// values, as a normalized_error field to group errors by. The error itself is
+// logged as usual.
+func WithNormalizeErrorMessage() Option {
+	return func(h *QueryHook) {
+		h.normErrorMsg = true
+	}
+}
+
+// WithPgErrorObject configures the hook to log, on Postgres errors, their
+// code, message, detail, hint and context as an object with the given key.
+func WithPgErrorObject(key string) Option {
+	return func(h *QueryHook) {
+		h.pgErrorKey = key
+	}
+}
+
+// WithBunOperationField configures the hook to log the operation of the
+// query, as reported by the bun query builder or parsed from raw queries, as
+// a field with the given key.
+func WithBunOperationField(key string) Option {
+	return func(h *QueryHook) {
+		h.operationKey = key
+	}
+}
+
+// WithReturnedRowsField configures the hook to log the number of rows
+// returned by SELECT queries, when known, e.g. when scanned into a model, as
+// a field with the given key.
+func WithReturnedRowsField(key string) Option {
+	return func(h *QueryHook) {
+		h.returnedRowsKey = key
+	}
+}
+
+// WithLargeResultWarn configures the hook to log the SELECT queries returning
+// more than rowThreshold rows at the given level, with a large_result field,
+// even when verbose is off. The number of rows is only known in the cases
+// described in WithReturnedRowsField.
+func WithLargeResultWarn(rowThreshold int, level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.largeRows = int64(rowThreshold)
+		h.largeResultLvl = &level
+	}
+}
+
+// WithServerNoticeLevel configures the hook to log the Postgres NOTICE and
+// WARNING messages passed to ServerNotice at the given level.
+func WithServerNoticeLevel(level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.noticeLevel = &level
+	}
+}
+
+// WithAccessKindField configures the hook to log, under the given key,
+// whether the query only reads data ("read"), e.g. a SELECT, or may write it
+// ("write"), as told by its main statement and common table expressions.
+func WithAccessKindField(key string) Option {
+	return func(h *QueryHook) {
+		h.accessKindKey = key
+	}
+}
+
+// WithErrorDetailSampling configures the hook to log the Postgres error code,
+// detail, hint and context as fields on every Nth error of each query
+// fingerprint only, other errors being logged with their message alone.
+func WithErrorDetailSampling(every int) Option {
+	return func(h *QueryHook) {
+		if every > 0 {
+			h.errorSampler = newErrorSampler(every)
+		}
+	}
+}
+
+// WithMinimalSuccess configures the hook to drop the optional fields of
+// successful queries, keeping the duration only (and the query with a fixed
+// message). Failed queries keep all their fields.
+func WithMinimalSuccess() Option {
+	return func(h *QueryHook) {
+		h.minimalSuccess = true
+	}
+}
+
+// WithLevels configures the hook to make proper usage of zap levels.
+// Custom levels, e.g. zapcore.Level(-2) for TRACE, are supported.
+func WithLevels(queryLevel, errorLevel zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.queryLevel = queryLevel
+		h.errorLevel = errorLevel
+	}
+}
+
+// WithSQLStateLevels configures the hook to log the failed queries whose
+// Postgres SQLSTATE is in levels at the mapped level, e.g. 23505
+// (unique_violation) at WARN. Other errors are logged at the error level.
+func WithSQLStateLevels(levels map[string]zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.sqlStateLevels = make(map[string]zapcore.Level, len(levels))
+		for code, level := range levels {
+			h.sqlStateLevels[code] = level
+		}
+	}
+}
+
+// WithMeter configures the hook to record query durations and counts as
+// OpenTelemetry metrics using the given meter.
+func WithMeter(meter metric.Meter) Option {
+	return func(h *QueryHook) {
+		h.meter = meter
+	}
+}
+
+// WithAnnotateSpan configures the hook to set the query attributes, and the
+// error status on failure, on the span of the query context, if any.
+// No span is created nor ended.
+func WithAnnotateSpan() Option {
+	return func(h *QueryHook) {
+		h.annotateSpan = true
+	}
+}
+
+// WithLevelOverride configures the hook to log at the level returned by
+// override for the level it would log at, e.g. to log everything at DEBUG in
+// tests. WithMaxLevel still applies.
+func WithLevelOverride(override func(defaultLevel zapcore.Level) zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.levelOverride = override
+	}
+}
+
+// WithAtomicLevel configures the hook to log only at the levels enabled by
+// level, on top of the logger ones, so that the hook logs can be turned down
+// or off at runtime, e.g. by setting level to FATAL.
+func WithAtomicLevel(level zap.AtomicLevel) Option {
+	return func(h *QueryHook) {
+		h.levelGate = level
+	}
+}
+
+// WithMaxLevel configures the hook to never log above the given level:
+// e.g. with WARN, failed queries logged at ERROR are downgraded to WARN.
+func WithMaxLevel(level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.maxLevel = &level
+	}
+}
+
+// WithZeroRowsWriteLevel configures the hook to log INSERT, UPDATE and DELETE
+// queries affecting no rows at the given level, with a rows_affected field.
+// Such queries are logged even when verbose is off.
+// bun only passes the hook the result of raw ExecContext queries, and of
+// builder queries scanning rows back, e.g. with Returning. Other builder
+// writes, such as NewDelete().Exec without Returning, cannot be detected.
+func WithZeroRowsWriteLevel(level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.zeroRowsWrite = true
+		h.zeroRowsLevel = level
+	}
+}
+
+// WithAlwaysLogDDL configures the hook to log successful CREATE, ALTER, DROP
+// and TRUNCATE statements at the given level, even when verbose is off and
+// whatever the filters, throttling, sampling, WithSummaryOnly and
+// WithMaxConcurrentLogs.
+func WithAlwaysLogDDL(level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.ddlLevel = &level
+	}
+}
+
+// WithUnqualifiedWriteWarn configures the hook to log UPDATE and DELETE
+// queries without WHERE clause with an unqualified_write field, successful
+// ones at the given level, even when verbose is off and whatever the filters,
+// throttling, sampling, WithSummaryOnly and WithMaxConcurrentLogs.
+func WithUnqualifiedWriteWarn(level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.unqualifiedLvl = &level
+	}
+}
+
+// WithRowsAffectedLevels configures the hook to log the successful queries
+// affecting at least the MinRows of a tier at the level of the highest such
+// tier. Such queries are logged even when verbose is off.
+// As with WithZeroRowsWriteLevel, builder writes which do not scan rows back
+// are not tiered, bun not passing their result to the hook.
+func WithRowsAffectedLevels(tiers []RowsTier) Option {
+	return func(h *QueryHook) {
+		h.rowsTiers = make([]RowsTier, len(tiers))
+		copy(h.rowsTiers, tiers)
+		sort.Slice(h.rowsTiers, func(i, j int) bool {
+			return h.rowsTiers[i].MinRows < h.rowsTiers[j].MinRows
+		})
+	}
+}
+
+// WithMetricsNamespace configures the hook to prefix the names of its
+// metrics instruments, e.g. myapp.db.client.operations.
+func WithMetricsNamespace(prefix string) Option {
+	return func(h *QueryHook) {
+		h.metricsPrefix = prefix
+	}
+}
+
+// WithQueryLengthBuckets configures the hook to count the queries by length,
+// in bytes, into buckets with the given upper bounds.
+// See QueryHook.QueryLengthHistogram.
+func WithQueryLengthBuckets(bounds []int) Option {
+	return func(h *QueryHook) {
+		h.queryLengths = newHistogram(bounds)
+	}
+}
+
+// WithStatsFlush configures the hook to call fn with a snapshot of its stats
+// every interval, until the hook is closed, see QueryHook.Close. The option
+// is ignored when interval is not positive.
+func WithStatsFlush(interval time.Duration, fn func(Stats)) Option {
+	return func(h *QueryHook) {
+		h.flushInterval = interval
+		h.onStatsFlush = fn
+	}
+}
+
+// WithWindowSummary configures the hook to log at the given level, every
+// interval, a summary of the queries of the interval: their count, error
+// count, median and 95th percentile durations, and most frequent operations.
+// The summaries stop when the hook is closed, see QueryHook.Close, which logs
+// the last one.
+func WithWindowSummary(interval time.Duration, level zapcore.Level) Option {
+	return func(h *QueryHook) {
+		h.summaryEvery = interval
+		h.summaryLevel = level
+	}
+}
+
+// WithSummaryOnly configures the hook not to log the successful queries
+// individually, leaving them to WithWindowSummary, but for the ones logged
+// by WithAlwaysLogDDL and WithUnqualifiedWriteWarn.
+func WithSummaryOnly() Option {
+	return func(h *QueryHook) {
+		h.summaryOnly = true
+	}
+}
+
+// WithLogFirstOccurrence configures the hook to log the successful queries
+// of each shape, as given by Fingerprint, only the first time one is logged:
+// the occurrences dropped by the level, throttling, sampling or a middleware
+// do not count. Failed queries are always logged.
+func WithLogFirstOccurrence() Option {
+	return func(h *QueryHook) {
+		h.firstOnly = true
+	}
+}
+
+// WithForgetAfter configures WithLogFirstOccurrence to log the shapes again
+// once d has elapsed since they were last logged.
+func WithForgetAfter(d time.Duration) Option {
+	return func(h *QueryHook) {
+		h.forgetAfter = d
+	}
+}
+
+// WithGroup configures the hook to also count its stats in the group.
+func WithGroup(g *HookGroup) Option {
+	return func(h *QueryHook) {
+		h.group = g
+	}
+}
+
+// WithMaxConcurrentLogs configures the hook to log at most n successful
+// queries at once, dropping the others so that logging does not add to the
+// load. Dropped queries are counted in the stats. Failed queries, and the
+// ones logged by WithAlwaysLogDDL and WithUnqualifiedWriteWarn, are always
+// logged. The option is ignored when n is not positive.
+func WithMaxConcurrentLogs(n int) Option {
+	return func(h *QueryHook) {
+		if n > 0 {
+			h.logSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithDuration configures the hook to log the duration.
+func WithDuration() Option {
+	return func(h *QueryHook) {
+		h.duration = true
+	}
+}
+
+// defaultQueryHook returns a hook with the default configuration, before
+// options.
+func defaultQueryHook(logger *zap.Logger) *QueryHook {
+	return &QueryHook{
+		errorFieldName:  "error",
+		precision:       time.Millisecond,
+		logger:          logger,
+		enabled:         true,
+		verbose:         false,
+		durationAsField: false,
+		errorAsField:    false,
+		duration:        false,
+		trimComments:    false,
+		queryArgs:       false,
+		argMismatchWarn: false,
+		beforeQuery:     false,
+		beforeLevel:     zapcore.DebugLevel,
+		queryLevel:      zapcore.DebugLevel,
+		errorLevel:      zapcore.ErrorLevel,
+		samplingRatio:   1,
+		stats:           &queryStats{},
+	}
+}
+
+// NewQueryHook creates a new query hook.
+func NewQueryHook(logger *zap.Logger, opts ...Option) *QueryHook {
+	qh := defaultQueryHook(logger)
+
+	for _, opt := range opts {
+		opt(qh)
+	}
+
+	if qh.name != "" && qh.logger != nil {
+		qh.logger = qh.logger.With(zap.String("hook", qh.name))
+	}
+
+	if qh.meter != nil {
+		qh.metrics = newQueryMetrics(qh.meter, qh.metricsPrefix)
+	}
+
+	if qh.onStatsFlush != nil && qh.flushInterval > 0 {
+		qh.closers = append(qh.closers, qh.stats.flushEvery(qh.flushInterval, qh.onStatsFlush))
+	}
+
+	if qh.firstOnly {
+		qh.shapes = newShapeTracker(qh.forgetAfter)
+	}
+
+	if qh.summaryEvery > 0 {
+		qh.summary = newWindowSummary()
+		stop := every(qh.summaryEvery, qh.logSummary)
+		qh.closers = append(qh.closers, func() error {
+			err := stop()
+			qh.logSummary()
+			return err
+		})
+	}
+
+	if qh.collapser != nil {
+		stop := every(collapseInterval, func() { qh.logRepetition(qh.collapser.expire()) })
+		qh.closers = append(qh.closers, func() error {
+			err := stop()
+			qh.logRepetition(qh.collapser.flush())
+			return err
+		})
+	}
+
+	if qh.samplingRatio < 1 {
+		seed := time.Now().UnixNano()
+		if qh.samplingSeed != nil {
+			seed = *qh.samplingSeed
+		}
+		qh.sampler = newRatioSampler(qh.samplingRatio, seed)
+	}
+
+	return qh
+}
+
+// WithSyncErrorCallback configures the hook to report logger sync failures
+// to fn.
+func WithSyncErrorCallback(fn func(error)) Option {
+	return func(h *QueryHook) {
+		h.onSyncError = fn
+	}
+}
+
+// WithStrictOptions configures NewQueryHookChecked to fail when several
+// options set the same setting to different values.
+func WithStrictOptions() Option {
+	return func(h *QueryHook) {
+		h.strictOptions = true
+	}
+}
+
+// NewQueryHookChecked creates a new query hook like NewQueryHook, failing in
+// strict mode, see WithStrictOptions, when options conflict.
+func NewQueryHookChecked(logger *zap.Logger, opts ...Option) (*QueryHook, error) {
+	probe := defaultQueryHook(logger)
+	for _, opt := range opts {
+		opt(probe)
+	}
+
+	if probe.strictOptions {
+		if err := checkOptions(logger, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewQueryHook(logger, opts...), nil
+}
+
+// New creates a new query hook along with its Close method as a cleanup
+// function.
+func New(logger *zap.Logger, opts ...Option) (*QueryHook, func() error) {
+	qh := NewQueryHook(logger, opts...)
+
+	return qh, qh.Close
+}
+
+// Close releases the resources held by the hook, i.e. stops the goroutines
+// started by its options, running their last flush, then syncs the logger.
+// Close is safe to call more than once.
+func (h *QueryHook) Close() error {
+	h.closeOnce.Do(func() {
+		var err error
+		for i := len(h.closers) - 1; i >= 0; i-- {
+			err = multierr.Append(err, h.closers[i]())
+		}
+		h.closeErr = multierr.Append(err, h.Sync())
+	})
+
+	return h.closeErr
+}
+
+// Stats returns a snapshot of the counters of the hook.
+func (h *QueryHook) Stats() Stats {
+	return h.stats.snapshot()
+}
+
+// Reconfigure applies opts to the hook while it is in use. Only the options
+// changing the following settings are supported: WithVerbose, WithLevels,
+// WithMaxLevel, WithBeforeQuery, WithBeforeQueryLevel, WithDuration,
+// WithDurationAsField, WithSlowQueryCallback, WithSlowOnly and
+// WithSuppressFastErrors. Reconfigure fails, leaving the hook unchanged, when
+// an option changes other settings.
+func (h *QueryHook) Reconfigure(opts ...Option) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	before, after := defaultQueryHook(h.logger), defaultQueryHook(h.logger)
+	current := h.loadSettings()
+	before.storeSettings(current)
+	after.storeSettings(current)
+	for _, opt := range opts {
+		opt(after)
+	}
+
+	before.storeSettings(after.loadSettings())
+	if f, ok := differingField(before, after); ok {
+		return fmt.Errorf("option changes %s, which cannot be reconfigured", f)
+	}
+
+	h.storeSettings(after.loadSettings())
+
+	return nil
+}
+
+// settings are the settings Reconfigure can change, read at once by the hook
+// for each query.
+type settings struct {
+	verbose         bool
+	beforeQuery     bool
+	beforeLevel     zapcore.Level
+	queryLevel      zapcore.Level
+	errorLevel      zapcore.Level
+	maxLevel        *zapcore.Level
+	duration        bool
+	durationAsField bool
+	slowThreshold   time.Duration
+	onSlowQuery     func(event *bun.QueryEvent, dur time.Duration)
+	slowOnly        *time.Duration
+	minErrorDur     time.Duration
+}
+
+// currentSettings returns the settings to use for a query.
+func (h *QueryHook) currentSettings() settings {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.loadSettings()
+}
+
+func (h *QueryHook) loadSettings() settings {
+	return settings{
+		verbose:         h.verbose,
+		beforeQuery:     h.beforeQuery,
+		beforeLevel:     h.beforeLevel,
+		queryLevel:      h.queryLevel,
+		errorLevel:      h.errorLevel,
+		maxLevel:        h.maxLevel,
+		duration:        h.duration,
+		durationAsField: h.durationAsField,
+		slowThreshold:   h.slowThreshold,
+		onSlowQuery:     h.onSlowQuery,
+		slowOnly:        h.slowOnly,
+		minErrorDur:     h.minErrorDur,
+	}
+}
+
+func (h *QueryHook) storeSettings(s settings) {
+	h.verbose = s.verbose
+	h.beforeQuery = s.beforeQuery
+	h.beforeLevel = s.beforeLevel
+	h.queryLevel = s.queryLevel
+	h.errorLevel = s.errorLevel
+	h.maxLevel = s.maxLevel
+	h.duration = s.duration
+	h.durationAsField = s.durationAsField
+	h.slowThreshold = s.slowThreshold
+	h.onSlowQuery = s.onSlowQuery
+	h.slowOnly = s.slowOnly
+	h.minErrorDur = s.minErrorDur
+}
+
+// QueryLengthHistogram returns a snapshot of the query length counts, or nil
+// when WithQueryLengthBuckets is not used.
+func (h *QueryHook) QueryLengthHistogram() []HistogramBucket {
+	if h.queryLengths == nil {
+		return nil
+	}
+
+	return h.queryLengths.snapshot()
+}
+
+// Sync flushes the logger, reporting failures to the sync error callback.
+func (h *QueryHook) Sync() error {
+	if h.logger == nil {
+		return nil
+	}
+
+	err := h.logger.Sync()
+	if err != nil && h.onSyncError != nil {
+		h.onSyncError(err)
+	}
+
+	return err
+}
+
+// ServerNotice logs a message sent by the server along with the results of a
+// query, such as from RAISE NOTICE, at the level set by WithServerNoticeLevel.
+// The code, detail, hint and context of notices exposing Postgres fields are
+// logged as fields.
+// pgdriver discards notices: ServerNotice is meant to be called by drivers
+// exposing them, e.g. from the OnNotice callback of pgx.
+func (h *QueryHook) ServerNotice(notice error) {
+	if !h.enabled || h.noticeLevel == nil || notice == nil {
+		return
+	}
+
+	var fields []zap.Field
+	if pgNotice, ok := pgError(notice); ok {
+		fields = pgDetailFields(pgNotice)
+	}
+
+	h.log(*h.noticeLevel, fmt.Sprintf("notice: %s", notice), fields...)
+}
+
+func (h *QueryHook) BeforeQuery(ctx context.Context, event *bun.QueryEvent) context.Context {
+	if !h.enabled {
+		return ctx
+	}
+
+	if h.poolWaitKey != "" && event.DB != nil {
+		if event.Stash == nil {
+			event.Stash = make(map[interface{}]interface{})
+		}
+		event.Stash[poolWaitStashKey{}] = event.DB.Stats().WaitDuration
+	}
+
+	cfg := h.currentSettings()
+	if !cfg.beforeQuery {
+		return ctx
+	}
+
+	level := cfg.beforeLevel
+	if h.levelOverride != nil {
+		level = h.levelOverride(level)
+	}
+	if !h.logger.Core().Enabled(level) || h.levelGate != nil && !h.levelGate.Enabled(level) {
+		return ctx
+	}
+
+	query, _ := h.loggedQuery(event)
+	if !h.structStart {
+		h.log(level, fmt.Sprintf("start: %s", query))
+		return ctx
+	}
+
+	id := atomic.AddUint64(h.queryIDs, 1)
+	if event.Stash == nil {
+		event.Stash = make(map[interface{}]interface{})
+	}
+	event.Stash[queryIDStashKey{}] = id
+
+	h.log(level, "query start",
+		zap.String("query", query),
+		zap.Uint64("query_id", id),
+		zap.String("db.operation", strings.ToUpper(event.Operation())),
+	)
+
+	return ctx
+}
+
+func (h *QueryHook) AfterQuery(ctx context.Context, event *bun.QueryEvent) {
+	if !h.enabled {
+		return
+	}
+
+	now := time.Now()
+	dur := now.Sub(event.StartTime)
+
+	cfg := h.currentSettings()
+
+	h.stats.record(!isSuccess(event.Err))
+	if h.group != nil {
+		h.group.stats.record(!isSuccess(event.Err))
+	}
+
+	if h.summary != nil {
+		h.summary.observe(strings.ToUpper(event.Operation()), dur, !isSuccess(event.Err))
+	}
+
+	if h.queryLengths != nil {
+		h.queryLengths.observe(len(event.Query))
+	}
+
+	for _, observe := range h.observers {
+		observe(ctx, h.callbackEvent(event), dur)
+	}
+
+	if h.annotateSpan {
+		query, _ := h.loggedQuery(event)
+		annotateSpan(ctx, event, query, dur)
+	}
+
+	if h.argMismatchWarn {
+		h.checkArgs(event, cfg.maxLevel)
+	}
+
+	var level zapcore.Level
+	var err, dbErr error
+	var zeroRows, largeResult, limited, forced bool
+	unqualified := h.unqualifiedLvl != nil && isUnqualifiedWrite(event.Query)
+
+	verbose := cfg.verbose
+	if cfg.slowOnly != nil {
+		verbose = dur >= *cfg.slowOnly
+	}
+	if h.adaptive != nil && h.adaptive.observe(!isSuccess(event.Err)) {
+		verbose = true
+	}
+
+	switch event.Err {
+	case nil, sql.ErrNoRows, sql.ErrTxDone:
+		if h.metrics != nil {
+			h.metrics.record(ctx, event, dur, false)
+		}
+		if cfg.onSlowQuery != nil && dur > cfg.slowThreshold {
+			cfg.onSlowQuery(h.callbackEvent(event), dur)
+		}
+		zeroRows = h.zeroRowsWrite && isZeroRowsWrite(event)
+		tierLevel, tiered := h.rowsAffectedLevel(event)
+		if h.largeResultLvl != nil {
+			rows, ok := returnedRows(event)
+			largeResult = ok && rows > h.largeRows
+		}
+		if unqualified {
+			level, forced = *h.unqualifiedLvl, true
+			break
+		}
+		if h.ddlLevel != nil && isDDL(event) {
+			level, forced = *h.ddlLevel, true
+			break
+		}
+		if h.summaryOnly {
+			return
+		}
+		if !verbose && !zeroRows && !tiered && !largeResult {
+			return
+		}
+		if h.suppressProbes && isProbe(event.Query) {
+			return
+		}
+		if h.tableFilter != nil && !h.matchesTableFilter(event.Query) {
+			return
+		}
+		if h.fingerprints != nil {
+			if _, ok := h.fingerprints[Fingerprint(event.Query)]; !ok {
+				return
+			}
+		}
+		limited = true
+		level = cfg.queryLevel
+		if tiered {
+			level = tierLevel
+		}
+		if zeroRows {
+			level = h.zeroRowsLevel
+		}
+		if largeResult {
+			level = *h.largeResultLvl
+		}
+		err = nil
+	default:
+		if h.metrics != nil {
+			h.metrics.record(ctx, event, dur, true)
+		}
+		if h.errorBurst != nil && h.errorBurst.observe() {
+			h.onErrorBurst()
+		}
+		if h.onTimeout != nil && errors.Is(event.Err, context.DeadlineExceeded) {
+			h.onTimeout(h.callbackEvent(event), dur)
+		}
+		if dur < cfg.minErrorDur {
+			return
+		}
+		level = cfg.errorLevel
+		if pgErr, ok := pgError(event.Err); ok {
+			if l, ok := h.sqlStateLevels[pgErr.Field(pgFieldCode)]; ok {
+				level = l
+			}
+		}
+		err = event.Err
+		if h.ctxErrPriority && ctx != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+			dbErr = err
+			err = ctx.Err()
+		}
+	}
+
+	level, enabled := h.finalLevel(level, cfg.maxLevel)
+	if !enabled {
+		return
+	}
+
+	// Limited once the level is known to be enabled, not to count the
+	// queries that would not be logged anyway.
+	if limited {
+		if h.shapes != nil && h.shapes.known(Fingerprint(event.Query)) {
+			return
+		}
+		if limiter, ok := h.throttles[strings.ToUpper(event.Operation())]; ok && !limiter.Allow() {
+			return
+		}
+		if h.sampler != nil && !h.sampler.sample() {
+			return
+		}
+	}
+
+	if h.logSlots != nil && err == nil && !forced {
+		select {
+		case h.logSlots <- struct{}{}:
+			defer func() { <-h.logSlots }()
+		default:
+			h.stats.drop()
+			if h.group != nil {
+				h.group.stats.drop()
+			}
+			return
+		}
+	}
+
+	query, args := h.loggedQuery(event)
+
+	message := query
+	if err == nil && h.successMessage != nil {
+		message = h.successMessage(query)
+	}
+	fields := []zap.Field{}
+
+	structured := h.fixedMessage != ""
+	if structured {
+		message = h.fixedMessage
+		if h.queryEncoder != nil {
+			fields = append(fields, h.queryEncoder(query))
+		} else {
+			fields = append(fields, zap.String("query", query))
+		}
+	}
+
+	var templated bool
+	if !structured && (h.messageTmpl != nil || h.messageTmplErr != nil) {
+		var rendered string
+		if rendered, templated = h.renderMessage(MessageData{
+			Query:     query,
+			Duration:  h.round(dur),
+			Error:     err,
+			Operation: strings.ToUpper(event.Operation()),
+		}); templated {
+			message = rendered
+		}
+	}
+
+	if h.structuredQuery {
+		fields = append(fields, zap.String("db.operation", strings.ToUpper(event.Operation())))
+		if table := queryTable(query); table != "" {
+			fields = append(fields, zap.String("db.sql.table", table))
+		}
+		fields = append(fields, zap.String("db.statement", query))
+	}
+
+	if h.rawQueryKey != "" {
+		fields = append(fields,
+			zap.String(h.rawQueryKey, event.Query),
+			zap.String(h.normQueryKey, normalizeQuery(event.Query)),
+		)
+	}
+
+	if h.redactedKey != "" {
+		fields = append(fields, zap.String(h.redactedKey, h.redactQuery(event.Query)))
+	}
+
+	if h.commentKey != "" {
+		if comment := leadingComment(event.Query); comment != "" {
+			fields = append(fields, zap.String(h.commentKey, comment))
+		}
+	}
+
+	if h.pgStatQuery {
+		fields = append(fields, zap.String("normalized_query", pgStatNormalize(event.Query)))
+	}
+
+	if args != nil && h.argsJSONKey != "" {
+		fields = append(fields, zap.String(h.argsJSONKey, argsJSON(args)))
+	} else if args != nil {
+		fields = append(fields, zap.Any("args", args))
+	}
+
+	if h.operationKey != "" {
+		fields = append(fields, zap.String(h.operationKey, event.Operation()))
+	}
+
+	if h.accessKindKey != "" {
+		access := "write"
+		if isReadQuery(event.Query) {
+			access = "read"
+		}
+		fields = append(fields, zap.String(h.accessKindKey, access))
+	}
+
+	if h.inTxKey != "" {
+		if inTx, ok := inTransaction(event); ok {
+			fields = append(fields, zap.Bool(h.inTxKey, inTx))
+		}
+	}
+
+	if h.returnedRowsKey != "" {
+		if rows, ok := returnedRows(event); ok {
+			fields = append(fields, zap.Int64(h.returnedRowsKey, rows))
+		}
+	}
+
+	if zeroRows {
+		fields = append(fields, zap.Int64("rows_affected", 0))
+	}
+
+	if unqualified {
+		fields = append(fields, zap.Bool("unqualified_write", true))
+	}
+
+	if largeResult {
+		fields = append(fields, zap.Bool("large_result", true))
+	}
+
+	// The message and the fields share the rounded duration so they never
+	// disagree.
+	rounded := h.round(dur)
+	if cfg.duration && h.nativeDuration {
+		fields = append(fields, zap.Duration("duration", rounded))
+	} else if cfg.duration && (cfg.durationAsField || structured) {
+		fields = append(fields, zap.Field{
+			Key:       "duration",
+			Type:      zapcore.StringerType,
+			Interface: rounded,
+		})
+	} else if cfg.duration && !templated {
+		message = fmt.Sprintf("duration: %s %s", rounded, message)
+	}
+
+	if h.durationValKey != "" {
+		fields = append(fields,
+			zap.Int64(h.durationValKey, int64(rounded/h.precision)),
+			zap.String(h.durationUnitKey, durationUnit(h.precision)),
+		)
+	}
+
+	if waitBefore, ok := event.Stash[poolWaitStashKey{}].(time.Duration); ok && event.DB != nil {
+		fields = append(fields, zap.Duration(h.poolWaitKey, event.DB.Stats().WaitDuration-waitBefore))
+	}
+
+	if h.durationObjKey != "" {
+		fields = append(fields, zap.Object(h.durationObjKey, durationObject{dur: rounded, precision: h.precision}))
+	}
+
+	if h.slowDurKey != "" && dur > h.slowDurOver {
+		fields = append(fields, zap.Duration(h.slowDurKey, rounded))
+	}
+
+	if h.budgetKey != "" && ctx != nil {
+		if deadline, ok := ctx.Deadline(); ok {
+			fields = append(fields, zap.Duration(h.budgetKey, deadline.Sub(event.StartTime)))
+		}
+	}
+
+	if err != nil {
+		if h.errorAsField || structured {
+			fields = append(fields, zap.Field{
+				Key:       h.errorFieldName,
+				Type:      zapcore.ErrorType,
+				Interface: err,
+			})
+		}
+		if (!h.errorAsField && !structured || h.errorBoth) && !templated {
+			message = fmt.Sprintf("%s error: %s", message, err)
+		}
+		if dbErr != nil {
+			fields = append(fields, zap.NamedError("db_error", dbErr))
+		}
+		if h.expandOnError {
+			if expanded, ok := h.expandedQuery(event); ok {
+				fields = append(fields, zap.String("expanded_query", expanded))
+			}
+		}
+		fields = append(fields, h.errorFields...)
+		if ctx != nil {
+			for _, ctxFields := range h.errorCtxFields {
+				fields = append(fields, ctxFields(ctx)...)
+			}
+		}
+		if h.errSummaryKey != "" {
+			fields = append(fields, zap.String(h.errSummaryKey, errorSummary(err, h.errSummaryLen)))
+		}
+		if h.normErrorMsg {
+			fields = append(fields, zap.String("normalized_error", normalizeErrorMessage(err)))
+		}
+		if h.errorGroupKey != "" {
+			fields = append(fields, zap.String(h.errorGroupKey, errorGroup(err)))
+		}
+		if h.errorStartKey != "" {
+			fields = append(fields, zap.Time(h.errorStartKey, event.StartTime))
+		}
+		if pgErr, ok := pgError(event.Err); ok {
+			if h.pgErrorKey != "" {
+				fields = append(fields, zap.Object(h.pgErrorKey, pgErrorObject{err: pgErr}))
+			}
+			switch {
+			case h.errorSampler != nil:
+				if h.errorSampler.sample(Fingerprint(event.Query)) {
+					fields = append(fields, pgDetailFields(pgErr)...)
+				}
+			case h.pgErrorContext && pgErr.Field(pgFieldWhere) != "":
+				fields = append(fields, zap.String("db.error.context", pgErr.Field(pgFieldWhere)))
+			}
+		}
+	} else if h.resultErrorKey != "" && event.Result != nil {
+		if resErr := resultError(event.Result); resErr != nil {
+			fields = append(fields, zap.Field{
+				Key:       h.resultErrorKey,
+				Type:      zapcore.ErrorType,
+				Interface: resErr,
+			})
+		}
+	}
+
+	if !structured {
+		message += h.messageSuffix
+	}
+
+	fields = append(fields, h.contextValueFields(ctx)...)
+
+	if h.opNameKey != "" && ctx != nil {
+		if name, ok := ctx.Value(operationNameKey{}).(string); ok && name != "" {
+			fields = append(fields, zap.String(h.opNameKey, name))
+		}
+	}
+
+	if h.isolationKey != "" && ctx != nil {
+		isolation, ok := ctx.Value(isolationLevelKey{}).(sql.IsolationLevel)
+		if inTx, known := inTransaction(event); ok && isolation != sql.LevelDefault && (inTx || !known) {
+			fields = append(fields, zap.String(h.isolationKey, isolation.String()))
+		}
+	}
+
+	if h.attempt != nil {
+		if attempt := h.attempt(ctx); attempt > 0 {
+			fields = append(fields, zap.Int(h.attemptKey, attempt))
+		}
+	}
+
+	if id, ok := event.Stash[queryIDStashKey{}].(uint64); ok {
+		fields = append(fields, zap.Uint64("query_id", id))
+	}
+
+	if h.sequence != nil {
+		fields = append(fields, zap.Uint64(h.sequenceKey, atomic.AddUint64(h.sequence, 1)))
+	}
+
+	if h.callerKey != "" {
+		if caller, ok := queryCaller(h.callerSkip); ok {
+			fields = append(fields, zap.String(h.callerKey, caller.TrimmedPath()))
+		}
+	}
+
+	for _, eventFields := range h.eventFields {
+		fields = append(fields, eventFields(event)...)
+	}
+
+	if h.correlation != nil {
+		if f := h.correlation(ctx); f.Type != zapcore.SkipType {
+			fields = append(fields, f)
+		}
+	}
+
+	if err == nil && h.minimalSuccess {
+		fields = h.minimalFields(fields)
+	}
+
+	if h.collapser != nil {
+		skip, pending := h.collapser.collapse(Fingerprint(event.Query), level, message)
+		h.logRepetition(pending)
+		if skip {
+			return
+		}
+	}
+
+	// Explained last, once the query is known to be logged.
+	if h.explainDB != nil && err == nil && !h.minimalSuccess && dur >= h.explainAfter &&
+		(ctx == nil || ctx.Value(explainKey{}) == nil) && explainable(event.Query) {
+		if plan, ok := h.explain(ctx, event); ok {
+			fields = append(fields, zap.String("plan", plan))
+		}
+	}
+
+	if h.fieldOrder != nil {
+		h.sortFields(fields)
+	}
+
+	// The shape is recorded once nothing can drop the log anymore.
+	next := func() {
+		if limited && h.shapes != nil && !h.shapes.first(Fingerprint(event.Query)) {
+			return
+		}
+		h.log(level, message, fields...)
+	}
+	if len(h.middlewares) == 0 {
+		next()
+		return
+	}
+
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		middleware, inner := h.middlewares[i], next
+		next = func() { middleware(inner) }
+	}
+	next()
+}
+
+// logRepetition logs the number of repetitions of a collapsed log.
+func (h *QueryHook) logRepetition(r *repetition) {
+	if r != nil {
+		h.log(r.level, r.message, zap.Int("repeated", r.repeated))
+	}
+}
+
+// logSummary logs the summary of the current window, if any query ran.
+func (h *QueryHook) logSummary() {
+	if fields := h.summary.flush(); fields != nil {
+		h.log(h.summaryLevel, "query summary", fields...)
+	}
+}
+
+// log logs an entry, or passes it to the sink if any, along with the hook
+// name field the logger would add.
+func (h *QueryHook) log(level zapcore.Level, message string, fields ...zap.Field) {
+	if h.sink != nil {
+		if !h.logger.Core().Enabled(level) {
+			return
+		}
+		if h.name != "" {
+			fields = append([]zap.Field{zap.String("hook", h.name)}, fields...)
+		}
+		h.sink(level, message, fields)
+		return
+	}
+
+	h.logger.Log(level, message, fields...)
+}
+
+// finalLevel returns level overridden and capped as configured, and whether
+// it is enabled.
+func (h *QueryHook) finalLevel(level zapcore.Level, maxLevel *zapcore.Level) (zapcore.Level, bool) {
+	if h.levelOverride != nil {
+		level = h.levelOverride(level)
+	}
+
+	if maxLevel != nil && level > *maxLevel {
+		level = *maxLevel
+	}
+
+	// Checked here to skip building the fields of the entries the core would
+	// drop. The atomic level, see WithAtomicLevel, applies on top of the core.
+	enabled := h.logger.Core().Enabled(level) && (h.levelGate == nil || h.levelGate.Enabled(level))
+
+	return level, enabled
+}
+
+// minimalFields filters out all fields but the query and duration ones.
+func (h *QueryHook) minimalFields(fields []zap.Field) []zap.Field {
+	kept := fields[:0]
+	for _, f := range fields {
+		switch f.Key {
+		case "query", "duration", h.durationValKey, h.durationUnitKey:
+			if f.Key != "" {
+				kept = append(kept, f)
+			}
+		}
+	}
+
+	return kept
+}
+
+// sortFields moves the fields listed in the field order first, keeping the
+// relative order of the others.
+func (h *QueryHook) sortFields(fields []zap.Field) {
+	rank := func(f zap.Field) int {
+		if i, ok := h.fieldOrder[f.Key]; ok {
+			return i
+		}
+		return len(h.fieldOrder)
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool { return rank(fields[i]) < rank(fields[j]) })
+}
+
+// loggedQuery returns the query to log, transformed as configured, along with
+// its arguments when they are logged separately.
+func (h *QueryHook) loggedQuery(event *bun.QueryEvent) (string, []interface{}) {
+	query := event.Query
+	var args []interface{}
+	if h.queryArgs && len(event.QueryArgs) > 0 {
+		query = event.QueryTemplate
+		args = h.truncateArgs(h.redactArgs(query, event.QueryArgs))
+	}
+
+	if h.trimComments {
+		query = stripComments(query)
+	}
+	if h.unquoteIdents {
+		query = unquoteIdentifiers(query)
+	}
+	for _, prefix := range h.stripPrefixes {
+		if len(query) >= len(prefix) && strings.EqualFold(query[:len(prefix)], prefix) {
+			query = strings.TrimLeft(query[len(prefix):], " \t\n")
+			break
+		}
+	}
+	if h.replacer != nil {
+		query = h.replacer.Replace(query)
+	}
+	if h.obfuscator != nil {
+		query, args = h.obfuscator.obfuscate(query), nil
+	}
+
+	return query, args
+}
+
+// contextValueFields returns the configured context values as fields,
+// sorted by field name.
+func (h *QueryHook) contextValueFields(ctx context.Context) []zap.Field {
+	if len(h.contextFields) == 0 || ctx == nil {
+		return nil
+	}
+
+	fields := make([]zap.Field, 0, len(h.contextFields))
+	for key, name := range h.contextFields {
+		if value := ctx.Value(key); value != nil {
+			fields = append(fields, zap.Any(name, value))
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+
+	return fields
+}
+
+// callbackEvent returns the event to hand to callbacks: a snapshot when
+// cloning is on, the event itself otherwise.
+func (h *QueryHook) callbackEvent(event *bun.QueryEvent) *bun.QueryEvent {
+	if !h.cloneEvent {
+		return event
+	}
+
+	clone := *event
+	if event.QueryArgs != nil {
+		clone.QueryArgs = make([]interface{}, len(event.QueryArgs))
+		copy(clone.QueryArgs, event.QueryArgs)
+	}
+	if event.Stash != nil {
+		clone.Stash = make(map[interface{}]interface{}, len(event.Stash))
+		for k, v := range event.Stash {
+			clone.Stash[k] = v
+		}
+	}
+
+	return &clone
+}
+
+// matchesTableFilter reports whether the query references one of the
+// filtered tables.
+func (h *QueryHook) matchesTableFilter(query string) bool {
+	for _, table := range queryTables(query) {
+		table = strings.ToLower(table)
+		if _, ok := h.tableFilter[table]; ok {
+			return true
+		}
+		if i := strings.LastIndexByte(table, '.'); i >= 0 {
+			if _, ok := h.tableFilter[table[i+1:]]; ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// inTransaction reports whether the query ran on a transaction. ok is false
+// when it cannot be told, i.e. for raw queries.
+func inTransaction(event *bun.QueryEvent) (inTx, ok bool) {
+	q, ok := event.IQuery.(interface{ GetConn() bun.IConn })
+	if !ok {
+		return false, false
+	}
+
+	switch q.GetConn().(type) {
+	case *sql.Tx, bun.Tx, *bun.Tx:
+		return true, true
+	default:
+		return false, true
+	}
+}
+
+// isSuccess reports whether the query error denotes a successful query.
+func isSuccess(err error) bool {
+	switch err {
+	case nil, sql.ErrNoRows, sql.ErrTxDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkArgs logs a warning when the placeholders of a raw query do not match
+// its arguments. Queries built with the query builder are not checked.
+func (h *QueryHook) checkArgs(event *bun.QueryEvent, maxLevel *zapcore.Level) {
+	if event.IQuery != nil {
+		return
+	}
+
+	placeholders, ok := countPlaceholders(event.QueryTemplate)
+	if !ok || placeholders == len(event.QueryArgs) {
+		return
+	}
+
+	level, enabled := h.finalLevel(zapcore.WarnLevel, maxLevel)
+	if !enabled {
+		return
+	}
+
+	h.log(level, "query placeholders and args mismatch",
+		zap.String("query", event.QueryTemplate),
+		zap.Int("placeholders", placeholders),
+		zap.Int("args", len(event.QueryArgs)),
+	)
+}
+
+// redactArgs returns a copy of args where the arguments bound to one of the
+// redacted columns are masked.
+func (h *QueryHook) redactArgs(query string, args []interface{}) []interface{} {
+	if len(h.redactColumns) == 0 && h.redactValues == nil {
+		return args
+	}
+
+	redacted := make([]interface{}, len(args))
+	copy(redacted, args)
+
+	if h.redactValues != nil {
+		for i, arg := range redacted {
+			if s, ok := arg.(string); ok && h.redactValues.MatchString(s) {
+				redacted[i] = redactedValue
+			}
+		}
+	}
+
+	for i, column := range placeholderColumns(query) {
+		if i >= len(redacted) {
+			break
+		}
+		if _, ok := h.redactColumns[strings.ToLower(column)]; ok {
+			redacted[i] = redactedValue
+		}
+	}
+
+	return redacted
+}
+
+// truncateArgs returns a copy of args with the long strings and byte slices
+// truncated, or args itself when there are none.
+func (h *QueryHook) truncateArgs(args []interface{}) []interface{} {
+	if h.argMaxLen <= 0 {
+		return args
+	}
+
+	var truncated []interface{}
+	for i, arg := range args {
+		var value interface{}
+		switch arg := arg.(type) {
+		case string:
+			if utf8.RuneCountInString(arg) <= h.argMaxLen {
+				continue
+			}
+			value = string([]rune(arg)[:h.argMaxLen]) + "…"
+		case []byte:
+			if len(arg) <= h.argMaxLen {
+				continue
+			}
+			value = append(arg[:h.argMaxLen:h.argMaxLen], "…"...)
+		default:
+			continue
+		}
+		if truncated == nil {
+			truncated = make([]interface{}, len(args))
+			copy(truncated, args)
+		}
+		truncated[i] = value
+	}
+
+	if truncated == nil {
+		return args
+	}
+
+	return truncated
+}
+
+// expandedQuery returns the query with its arguments expanded, redacted and
+// truncated as the logged arguments. It reports false when they cannot be
+// expanded again.
+func (h *QueryHook) expandedQuery(event *bun.QueryEvent) (string, bool) {
+	if len(event.QueryArgs) == 0 || len(h.redactColumns) == 0 && h.redactValues == nil && h.argMaxLen <= 0 {
+		return event.Query, true
+	}
+	if event.DB == nil {
+		return "", false
+	}
+
+	args := h.truncateArgs(h.redactArgs(event.QueryTemplate, event.QueryArgs))
+
+	return event.DB.Formatter().FormatQuery(event.QueryTemplate, args...), true
+}
+
+// round rounds dur to the precision according to the rounding mode.
+func (h *QueryHook) round(dur time.Duration) time.Duration {
+	switch h.rounding {
+	case RoundingFloor:
+		return dur.Truncate(h.precision)
+	case RoundingCeil:
+		if truncated := dur.Truncate(h.precision); truncated != dur {
+			return truncated + h.precision
+		}
+		return dur
+	default:
+		return dur.Round(h.precision)
+	}
+}
+
+// errorSummary returns the error message with its whitespace collapsed, cut
+// to maxLen characters if positive.
+func errorSummary(err error, maxLen int) string {
+	summary := []rune(strings.Join(strings.Fields(err.Error()), " "))
+	if maxLen > 0 && len(summary) > maxLen {
+		summary = summary[:maxLen]
+	}
+
+	return string(summary)
+}
+
+// rowsAffectedLevel returns the level of the highest rows tier reached by the
+// query, if any.
+func (h *QueryHook) rowsAffectedLevel(event *bun.QueryEvent) (zapcore.Level, bool) {
+	if len(h.rowsTiers) == 0 || event.Result == nil {
+		return 0, false
+	}
+
+	rows, err := event.Result.RowsAffected()
+	if err != nil {
+		return 0, false
+	}
+
+	for i := len(h.rowsTiers) - 1; i >= 0; i-- {
+		if rows >= h.rowsTiers[i].MinRows {
+			return h.rowsTiers[i].Level, true
+		}
+	}
+
+	return 0, false
+}
+
+// returnedRows returns the number of rows returned by a SELECT query, if
+// known.
+func returnedRows(event *bun.QueryEvent) (int64, bool) {
+	if event.Result == nil || !strings.EqualFold(event.Operation(), "SELECT") {
+		return 0, false
+	}
+
+	rows, err := event.Result.RowsAffected()
+	if err != nil {
+		return 0, false
+	}
+
+	return rows, true
+}
+
+// isDDL reports whether the event is a schema-modifying query, as told by
+// the first keyword of the query: bun names the operations of builder
+// queries after the statement and its object, e.g. CREATE TABLE or
+// ADD COLUMN, and those of NewRaw queries SELECT.
+func isDDL(event *bun.QueryEvent) bool {
+	tokens := tokenize(event.Query)
+	if len(tokens) == 0 {
+		return false
+	}
+
+	switch strings.ToUpper(tokens[0].text) {
+	case "CREATE", "ALTER", "DROP", "TRUNCATE":
+		return true
+	default:
+		return false
+	}
+}
+
+// isZeroRowsWrite reports whether the event is a data-modifying query that
+// affected no rows.
+func isZeroRowsWrite(event *bun.QueryEvent) bool {
+	if event.Result == nil {
+		return false
+	}
+
+	switch strings.ToUpper(event.Operation()) {
+	case "INSERT", "UPDATE", "DELETE":
+	default:
+		return false
+	}
+
+	rows, err := event.Result.RowsAffected()
+
+	return err == nil && rows == 0
+}
+
+// durationUnit returns the unit symbol of the given precision.
+func durationUnit(precision time.Duration) string {
+	switch precision {
+	case time.Nanosecond:
+		return "ns"
+	case time.Microsecond:
+		return "us"
+	case time.Millisecond:
+		return "ms"
+	case time.Second:
+		return "s"
+	case time.Minute:
+		return "m"
+	case time.Hour:
+		return "h"
+	default:
+		return precision.String()
+	}
+}
+
+// durationObject marshals a duration as its value in the precision unit, the
+// unit and a human readable form.
+type durationObject struct {
+	dur       time.Duration
+	precision time.Duration
+}
+
+func (o durationObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt64("value", int64(o.dur/o.precision))
+	enc.AddString("unit", durationUnit(o.precision))
+	enc.AddString("human", o.dur.String())
+
+	return nil
+}
+
+// argsJSON returns args encoded as JSON, or a placeholder when they cannot be
+// encoded.
+func argsJSON(args []interface{}) string {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return unencodableArgs
+	}
+
+	return string(b)
+}
+
+// resultError returns the first error reported by the result, if any.
+func resultError(res sql.Result) error {
+	if _, err := res.RowsAffected(); err != nil {
+		return err
+	}
+	if _, err := res.LastInsertId(); err != nil {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// ratioSampler randomly keeps a ratio of the occurrences.
+type ratioSampler struct {
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	ratio float64
+}
+
+func newRatioSampler(ratio float64, seed int64) *ratioSampler {
+	return &ratioSampler{
+		rnd:   rand.New(rand.NewSource(seed)),
+		ratio: ratio,
+	}
+}
+
+// sample reports whether an occurrence is kept.
+func (s *ratioSampler) sample() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rnd.Float64() < s.ratio
+}
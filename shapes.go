@@ -0,0 +1,47 @@
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+// shapeTracker remembers the query shapes seen, each for ttl if not zero.
+type shapeTracker struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+func newShapeTracker(ttl time.Duration) *shapeTracker {
+	return &shapeTracker{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+// known reports whether the shape was seen and not forgotten since, without
+// recording it.
+func (t *shapeTracker) known(shape string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at, ok := t.seen[shape]
+	return ok && (t.ttl == 0 || t.now().Sub(at) < t.ttl)
+}
+
+// first records the shape and reports whether it is seen for the first time,
+// or the first time since it was forgotten.
+func (t *shapeTracker) first(shape string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if at, ok := t.seen[shape]; ok && (t.ttl == 0 || now.Sub(at) < t.ttl) {
+		return false
+	}
+	t.seen[shape] = now
+
+	return true
+}
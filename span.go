@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// annotateSpan sets the query attributes, and the error status on failure,
+// on the recording span of the context, if any. The span is not ended.
+func annotateSpan(ctx context.Context, event *bun.QueryEvent, query string, dur time.Duration) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("db.statement", query),
+		attribute.String("db.operation", strings.ToUpper(event.Operation())),
+		attribute.Float64("db.client.operation.duration", dur.Seconds()),
+	)
+
+	if !isSuccess(event.Err) {
+		span.RecordError(event.Err)
+		span.SetStatus(codes.Error, event.Err.Error())
+	}
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+func TestNewQueryHook_AnnotateSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	hook := NewQueryHook(zap.NewNop(), WithAnnotateSpan())
+
+	ctx, span := tracer.Start(context.Background(), "request")
+	hook.AfterQuery(ctx, &bun.QueryEvent{Query: "SELECT * FROM nop", StartTime: time.Now(), Err: errors.New("boom")})
+	require.Empty(t, recorder.Ended(), "Span not ended by the hook")
+	span.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "SELECT * FROM nop", attrs["db.statement"].AsString())
+	assert.Equal(t, "SELECT", attrs["db.operation"].AsString())
+	assert.Contains(t, attrs, attribute.Key("db.client.operation.duration"))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "boom", spans[0].Status().Description)
+}
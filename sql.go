@@ -0,0 +1,694 @@
+package db
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// stripComments removes line (--) and block (/* */) comments from the query,
+// leaving quoted literals and identifiers untouched.
+func stripComments(query string) string {
+	out := make([]byte, 0, len(query))
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '\'' || c == '"':
+			end := closingQuote(query, i)
+			out = append(out, query[i:end]...)
+			i = end - 1
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end - 1
+			}
+			out = trimTrailingBlanks(out)
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 3
+			}
+			if len(out) == 0 || isBlank(out[len(out)-1]) {
+				for i+1 < len(query) && (query[i+1] == ' ' || query[i+1] == '\t') {
+					i++
+				}
+			} else if i+1 < len(query) && !isBlank(query[i+1]) {
+				out = append(out, ' ')
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// leadingComment returns the block (/* */) or line (--) comment the query
+// starts with, delimiters included, or an empty string if none.
+func leadingComment(query string) string {
+	query = strings.TrimLeft(query, " \t\r\n")
+
+	switch {
+	case strings.HasPrefix(query, "/*"):
+		if end := strings.Index(query[2:], "*/"); end >= 0 {
+			return query[:end+4]
+		}
+	case strings.HasPrefix(query, "--"):
+		if end := strings.IndexByte(query, '\n'); end >= 0 {
+			return strings.TrimRight(query[:end], "\r")
+		}
+		return query
+	}
+
+	return ""
+}
+
+// isUnqualifiedWrite reports whether the query is an UPDATE or a DELETE
+// without WHERE clause. Subqueries and common table expressions are skipped
+// to find the main statement and its clause.
+func isUnqualifiedWrite(query string) bool {
+	depth := 0
+	write := false
+
+	for _, t := range tokenize(query) {
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth != 0 || t.kind != tokenIdent {
+			continue
+		}
+
+		switch strings.ToUpper(t.text) {
+		case "SELECT", "INSERT":
+			if !write {
+				return false
+			}
+		case "UPDATE", "DELETE":
+			write = true
+		case "WHERE":
+			if write {
+				return false
+			}
+		}
+	}
+
+	return write
+}
+
+// isReadQuery reports whether the query only reads data: a SELECT, VALUES,
+// TABLE, SHOW or EXPLAIN statement, possibly with common table expressions
+// as long as none of them modifies data.
+func isReadQuery(query string) bool {
+	tokens := tokenize(query)
+	for len(tokens) > 0 && tokens[0].text == "(" {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return true
+	}
+
+	switch strings.ToUpper(tokens[0].text) {
+	case "SELECT", "VALUES", "TABLE", "SHOW", "EXPLAIN":
+		return true
+	case "WITH":
+	default:
+		return false
+	}
+
+	depth := 0
+	for i, t := range tokens {
+		switch t.text {
+		case "(":
+			depth++
+			continue
+		case ")":
+			depth--
+			continue
+		}
+		if t.kind != tokenIdent {
+			continue
+		}
+
+		switch strings.ToUpper(t.text) {
+		case "INSERT", "UPDATE", "DELETE", "MERGE":
+			// FOR UPDATE locks the rows read.
+			prev := strings.ToUpper(tokens[i-1].text)
+			if depth == 0 && prev != "FOR" && prev != "KEY" || tokens[i-1].text == "(" {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isProbe reports whether the query is a health check probe, such as
+// SELECT 1 or an empty statement, comments aside.
+func isProbe(query string) bool {
+	tokens := tokenize(query)
+	for len(tokens) > 0 && tokens[len(tokens)-1].text == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	switch len(tokens) {
+	case 0:
+		return true
+	case 2:
+		return strings.EqualFold(tokens[0].text, "SELECT") && tokens[1].text == "1"
+	default:
+		return false
+	}
+}
+
+// reservedWords are the Postgres and MySQL reserved words, which keep their
+// quotes when used as identifiers.
+var reservedWords = map[string]struct{}{}
+
+func init() {
+	for _, w := range strings.Fields(`
+		add all alter analyse analyze and any array as asc asymmetric
+		authorization between binary both by case cast change check collate
+		collation column concurrently condition constraint create cross
+		current_catalog current_date current_role current_schema current_time
+		current_timestamp current_user database default deferrable delete desc
+		describe distinct do drop else end except exists explain false fetch
+		for foreign freeze from full grant group having if ilike in index
+		initially inner insert intersect interval into is isnull join key keys
+		kill lateral leading left like limit localtime localtimestamp lock
+		match natural not notnull null offset on only option or order out
+		outer overlaps partition placing primary range read references rename
+		repeat replace require restrict return returning revoke right row rows
+		schema select session_user set show similar some symmetric system_user
+		table tablesample then to trailing trigger true union unique update
+		usage user using values variadic verbose when where while window with
+		write`) {
+		reservedWords[w] = struct{}{}
+	}
+}
+
+// unquoteIdentifiers removes the double quotes and backticks around the
+// identifiers of the query, leaving string literals untouched. Identifiers
+// which would not be the same unquoted, i.e. not all lowercase, reserved
+// words or containing spaces, keep their quotes.
+func unquoteIdentifiers(query string) string {
+	out := make([]byte, 0, len(query))
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch c {
+		case '\'':
+			end := closingQuote(query, i)
+			out = append(out, query[i:end]...)
+			i = end - 1
+		case '"', '`':
+			end := closingQuote(query, i)
+			if end-i > 2 && query[end-1] == c && isBareIdent(query[i+1:end-1]) {
+				out = append(out, query[i+1:end-1]...)
+			} else {
+				out = append(out, query[i:end]...)
+			}
+			i = end - 1
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return string(out)
+}
+
+// isBareIdent reports whether s means the same quoted or not: an all
+// lowercase identifier other than a reserved word.
+func isBareIdent(s string) bool {
+	if !isPlainIdent(s) || strings.ToLower(s) != s {
+		return false
+	}
+	_, reserved := reservedWords[s]
+
+	return !reserved
+}
+
+func isPlainIdent(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentPart(s[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// closingQuote returns the index following the quote closing the literal
+// opened at start, treating doubled quotes as escapes.
+func closingQuote(query string, start int) int {
+	q := query[start]
+
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != q {
+			continue
+		}
+		if i+1 < len(query) && query[i+1] == q {
+			i++
+			continue
+		}
+		return i + 1
+	}
+
+	return len(query)
+}
+
+func trimTrailingBlanks(b []byte) []byte {
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenPlaceholder
+	tokenLiteral
+	tokenPunct
+)
+
+type sqlToken struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits the query into a flat list of tokens, dropping comments.
+// Quoted identifiers are returned unquoted.
+func tokenize(query string) []sqlToken {
+	var tokens []sqlToken
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case isBlank(c):
+			i++
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == '\'':
+			end := closingQuote(query, i)
+			tokens = append(tokens, sqlToken{kind: tokenLiteral, text: query[i:end]})
+			i = end
+		case c == '"' || c == '`':
+			end := closingQuote(query, i)
+			tokens = append(tokens, sqlToken{kind: tokenIdent, text: strings.Trim(query[i:end], string(c))})
+			i = end
+		case c == '?':
+			end := i + 1
+			for end < len(query) && isIdentPart(query[end]) {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenPlaceholder, text: query[i:end]})
+			i = end
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(query) && isIdentPart(query[end]) {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenIdent, text: query[i:end]})
+			i = end
+		case c >= '0' && c <= '9':
+			end := i + 1
+			for end < len(query) && (query[end] >= '0' && query[end] <= '9' || query[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenLiteral, text: query[i:end]})
+			i = end
+		case strings.IndexByte("<>!=", c) >= 0:
+			end := i + 1
+			for end < len(query) && strings.IndexByte("<>!=", query[end]) >= 0 {
+				end++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenPunct, text: query[i:end]})
+			i = end
+		default:
+			tokens = append(tokens, sqlToken{kind: tokenPunct, text: query[i : i+1]})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c == '$' || c >= '0' && c <= '9'
+}
+
+// placeholderColumns returns, for each ? placeholder of the query, the name
+// of the column it is bound to, or an empty string when it cannot be told.
+// Columns are inferred from INSERT column lists and from comparisons or
+// assignments such as "col = ?".
+func placeholderColumns(query string) []string {
+	tokens := tokenize(query)
+
+	var columns []string
+	for _, t := range tokens {
+		if t.kind == tokenPlaceholder {
+			columns = append(columns, "")
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	insertColumns, valuesAt := insertColumnList(tokens)
+
+	n, depth, value := 0, 0, 0
+	for i, t := range tokens {
+		if valuesAt > 0 && i > valuesAt && len(insertColumns) > 0 {
+			switch t.text {
+			case "(":
+				depth++
+				if depth == 1 {
+					value = 0
+				}
+			case ")":
+				depth--
+				if depth == 0 && (i+1 >= len(tokens) || tokens[i+1].text != ",") {
+					valuesAt = 0
+				}
+			case ",":
+				if depth == 1 {
+					value++
+				}
+			}
+		}
+
+		if t.kind != tokenPlaceholder {
+			continue
+		}
+
+		switch {
+		case valuesAt > 0 && i > valuesAt && depth > 0 && value < len(insertColumns):
+			columns[n] = insertColumns[value]
+		case i >= 2 && tokens[i-1].kind == tokenPunct && isComparison(tokens[i-1].text) && tokens[i-2].kind == tokenIdent:
+			columns[n] = tokens[i-2].text
+		}
+		n++
+	}
+
+	return columns
+}
+
+// insertColumnList returns the column list of an INSERT statement along with
+// the index of its VALUES keyword.
+func insertColumnList(tokens []sqlToken) ([]string, int) {
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0].text, "INSERT") {
+		return nil, 0
+	}
+
+	var columns []string
+	i := 1
+	for ; i < len(tokens) && tokens[i].text != "("; i++ {
+		if strings.EqualFold(tokens[i].text, "VALUES") {
+			return nil, 0
+		}
+	}
+	for i++; i < len(tokens) && tokens[i].text != ")"; i++ {
+		if tokens[i].kind == tokenIdent && (i+1 == len(tokens) || tokens[i+1].text != ".") {
+			columns = append(columns, tokens[i].text)
+		}
+	}
+	for ; i < len(tokens); i++ {
+		if tokens[i].kind == tokenIdent && strings.EqualFold(tokens[i].text, "VALUES") {
+			return columns, i
+		}
+	}
+
+	return nil, 0
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "=", "<>", "!=", "<", ">", "<=", ">=":
+		return true
+	}
+	return false
+}
+
+// countPlaceholders returns the number of positional ? placeholders of the
+// query, ignoring literals and comments. ok is false when the query also uses
+// indexed (?0) or named (?name) placeholders, which cannot be counted.
+func countPlaceholders(query string) (n int, ok bool) {
+	for _, t := range tokenize(query) {
+		if t.kind != tokenPlaceholder {
+			continue
+		}
+		if t.text != "?" {
+			return 0, false
+		}
+		n++
+	}
+
+	return n, true
+}
+
+// queryTable returns the name of the main table of the query, i.e. the one
+// following FROM, INTO or UPDATE, or an empty string when none is found.
+// Schema-qualified names are returned as is.
+func queryTable(query string) string {
+	tokens := tokenize(query)
+
+	depth := 0
+	for i, t := range tokens {
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth != 0 || t.kind != tokenIdent || i+1 >= len(tokens) || tokens[i+1].kind != tokenIdent {
+			continue
+		}
+		if !strings.EqualFold(t.text, "FROM") && !strings.EqualFold(t.text, "INTO") &&
+			!(i == 0 && strings.EqualFold(t.text, "UPDATE")) {
+			continue
+		}
+
+		table := tokens[i+1].text
+		for j := i + 2; j+1 < len(tokens) && tokens[j].text == "." && tokens[j+1].kind == tokenIdent; j += 2 {
+			table += "." + tokens[j+1].text
+		}
+		return table
+	}
+
+	return ""
+}
+
+// queryTables returns the names of the tables referenced by the query, i.e.
+// following FROM, JOIN, INTO or UPDATE, including in subqueries.
+func queryTables(query string) []string {
+	tokens := tokenize(query)
+
+	var tables []string
+	for i, t := range tokens {
+		if t.kind != tokenIdent || i+1 >= len(tokens) || tokens[i+1].kind != tokenIdent {
+			continue
+		}
+		if !strings.EqualFold(t.text, "FROM") && !strings.EqualFold(t.text, "JOIN") &&
+			!strings.EqualFold(t.text, "INTO") && !strings.EqualFold(t.text, "UPDATE") {
+			continue
+		}
+
+		table := tokens[i+1].text
+		j := i + 2
+		for ; j+1 < len(tokens) && tokens[j].text == "." && tokens[j+1].kind == tokenIdent; j += 2 {
+			table += "." + tokens[j+1].text
+		}
+		// Skip function calls, e.g. FROM now() or FROM generate_series(...).
+		isFrom := strings.EqualFold(t.text, "FROM") || strings.EqualFold(t.text, "JOIN")
+		if isFrom && j < len(tokens) && tokens[j].text == "(" {
+			continue
+		}
+		tables = append(tables, table)
+	}
+
+	return tables
+}
+
+// Fingerprint returns a stable identifier of the shape of the query: queries
+// differing only by their literal values, comments, whitespace, keyword case
+// or the length of their placeholder lists share the same fingerprint.
+func Fingerprint(query string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalizeQuery(query)))
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// normalizeQuery returns the query with literals and placeholders replaced by
+// ?, lists of them collapsed, comments removed and identifiers lowercased.
+func normalizeQuery(query string) string {
+	tokens := tokenize(query)
+	parts := make([]string, 0, len(tokens))
+
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenLiteral, tokenPlaceholder:
+			// Collapse "?, ?, ?" into "?".
+			if n := len(parts); n >= 2 && parts[n-1] == "," && parts[n-2] == "?" {
+				parts = parts[:n-1]
+				continue
+			}
+			parts = append(parts, "?")
+		case tokenIdent:
+			parts = append(parts, strings.ToLower(t.text))
+		default:
+			parts = append(parts, t.text)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// pgStatNormalize returns the query with its constants replaced by $N
+// parameters, numbered after the parameters already in the query, as
+// pg_stat_statements does. The rest of the query text is kept as is.
+//
+// Known divergences from Postgres, which works on the parsed query:
+//   - the sign of negative numbers is kept out of the parameter;
+//   - typed literals, such as DATE '2022-01-01', keep their type name;
+//   - TRUE, FALSE and NULL are kept, as keywords;
+//   - constants in comments or dollar-quoted strings are not replaced.
+func pgStatNormalize(query string) string {
+	var parts []string
+	var constants []int
+	params := 0
+
+	constant := func() {
+		constants = append(constants, len(parts))
+		parts = append(parts, "")
+	}
+
+	start := 0
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+		case c == '"':
+			i = closingQuote(query, i)
+		case c == '\'' || c == '?' || c >= '0' && c <= '9':
+			parts = append(parts, query[start:i])
+			constant()
+			switch c {
+			case '\'':
+				i = closingQuote(query, i)
+			case '?':
+				i++
+				for i < len(query) && isIdentPart(query[i]) {
+					i++
+				}
+			default:
+				i = numberEnd(query, i)
+			}
+			start = i
+		case c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			end := i + 1
+			n := 0
+			for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+				n = n*10 + int(query[end]-'0')
+				end++
+			}
+			if n > params {
+				params = n
+			}
+			i = end
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(query) && isIdentPart(query[end]) {
+				end++
+			}
+			// E'...' escape strings and B'...'/X'...' bit strings are
+			// single constants.
+			if end == i+1 && end < len(query) && query[end] == '\'' && strings.IndexByte("eEbBxX", c) >= 0 {
+				parts = append(parts, query[start:i])
+				constant()
+				end = closingQuote(query, end)
+				start = end
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	parts = append(parts, query[start:])
+
+	for n, i := range constants {
+		parts[i] = fmt.Sprintf("$%d", params+n+1)
+	}
+
+	return strings.Join(parts, "")
+}
+
+// numberEnd returns the index following the numeric constant starting at
+// start, exponent included.
+func numberEnd(query string, start int) int {
+	i := start
+	for i < len(query) && (query[i] >= '0' && query[i] <= '9' || query[i] == '.') {
+		i++
+	}
+	if i < len(query) && (query[i] == 'e' || query[i] == 'E') {
+		j := i + 1
+		if j < len(query) && (query[j] == '+' || query[j] == '-') {
+			j++
+		}
+		if j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+
+	return i
+}
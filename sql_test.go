@@ -0,0 +1,399 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripComments(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    string
+	}{
+		{
+			description: "No comment",
+			query:       "SELECT 1",
+			expected:    "SELECT 1",
+		},
+		{
+			description: "Line comment",
+			query:       "SELECT 1 -- trailing\nFROM t -- end",
+			expected:    "SELECT 1\nFROM t",
+		},
+		{
+			description: "Block comment",
+			query:       "/* app:api */ SELECT /* cols */ a,/*x*/b FROM t",
+			expected:    "SELECT a, b FROM t",
+		},
+		{
+			description: "Block comment between words",
+			query:       "SELECT/* x */1",
+			expected:    "SELECT 1",
+		},
+		{
+			description: "Literal containing comment markers",
+			query:       "SELECT '-- not a comment', 'it''s /* kept */' -- dropped",
+			expected:    "SELECT '-- not a comment', 'it''s /* kept */'",
+		},
+		{
+			description: "Quoted identifier containing comment markers",
+			query:       `SELECT "a--b" FROM t`,
+			expected:    `SELECT "a--b" FROM t`,
+		},
+		{
+			description: "Unterminated block comment",
+			query:       "SELECT 1 /* oops",
+			expected:    "SELECT 1",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, stripComments(tc.query), tc.description)
+	}
+}
+
+func TestPlaceholderColumns(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    []string
+	}{
+		{
+			description: "No placeholder",
+			query:       "SELECT 1",
+			expected:    nil,
+		},
+		{
+			description: "Insert",
+			query:       "INSERT INTO users (name, password) VALUES (?, ?)",
+			expected:    []string{"name", "password"},
+		},
+		{
+			description: "Insert with quoted columns and several rows",
+			query:       `INSERT INTO "users" ("name", "token") VALUES (?, lower(?)), (?, ?) RETURNING id`,
+			expected:    []string{"name", "token", "name", "token"},
+		},
+		{
+			description: "Update",
+			query:       "UPDATE users AS u SET password = ?, name=? WHERE u.id = ?",
+			expected:    []string{"password", "name", "id"},
+		},
+		{
+			description: "Unparseable placeholders",
+			query:       "SELECT * FROM f(?, ?) WHERE 'a = ?' = ?",
+			expected:    []string{"", "", ""},
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, placeholderColumns(tc.query), tc.description)
+	}
+}
+
+func TestCountPlaceholders(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    int
+		ok          bool
+	}{
+		{
+			description: "Positional placeholders",
+			query:       "SELECT * FROM t WHERE a = ? AND b IN (?, ?)",
+			expected:    3,
+			ok:          true,
+		},
+		{
+			description: "Placeholders in literals and comments ignored",
+			query:       "SELECT '?' /* ? */ FROM t WHERE a = ? -- ?",
+			expected:    1,
+			ok:          true,
+		},
+		{
+			description: "Named placeholders",
+			query:       "SELECT * FROM ?TableName WHERE a = ?",
+			expected:    0,
+			ok:          false,
+		},
+		{
+			description: "Indexed placeholders",
+			query:       "SELECT ?0, ?1",
+			expected:    0,
+			ok:          false,
+		},
+	}
+
+	for _, tc := range cases {
+		n, ok := countPlaceholders(tc.query)
+		assert.Equal(t, tc.expected, n, tc.description)
+		assert.Equal(t, tc.ok, ok, tc.description)
+	}
+}
+
+func TestQueryTable(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    string
+	}{
+		{
+			description: "Select",
+			query:       "SELECT id, total FROM orders WHERE id = 1",
+			expected:    "orders",
+		},
+		{
+			description: "Select from subquery first",
+			query:       "SELECT * FROM (SELECT 1 FROM a) AS s",
+			expected:    "",
+		},
+		{
+			description: "Insert with quoted schema",
+			query:       `INSERT INTO "public"."users" ("name") VALUES ('a')`,
+			expected:    "public.users",
+		},
+		{
+			description: "Update",
+			query:       "UPDATE users SET name = 'b'",
+			expected:    "users",
+		},
+		{
+			description: "Delete",
+			query:       "DELETE FROM sessions WHERE expired",
+			expected:    "sessions",
+		},
+		{
+			description: "Extract is not a table",
+			query:       "SELECT extract(year FROM now())",
+			expected:    "",
+		},
+		{
+			description: "No table",
+			query:       "SELECT 1",
+			expected:    "",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, queryTable(tc.query), tc.description)
+	}
+}
+
+func TestQueryTables(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    []string
+	}{
+		{
+			description: "Join and subquery",
+			query:       "SELECT * FROM orders o JOIN public.users u ON u.id = o.user_id WHERE o.id IN (SELECT id FROM refunds)",
+			expected:    []string{"orders", "public.users", "refunds"},
+		},
+		{
+			description: "Insert select",
+			query:       "INSERT INTO archive SELECT * FROM events",
+			expected:    []string{"archive", "events"},
+		},
+		{
+			description: "Extract is not a table",
+			query:       "SELECT extract(year FROM now())",
+			expected:    nil,
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, queryTables(tc.query), tc.description)
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    string
+	}{
+		{
+			description: "Literals replaced",
+			query:       "SELECT * FROM users WHERE id = 42 AND name = 'alice'",
+			expected:    "select * from users where id = ? and name = ?",
+		},
+		{
+			description: "Lists collapsed",
+			query:       "SELECT * FROM users WHERE id IN (1, 2, 3)",
+			expected:    "select * from users where id in ( ? )",
+		},
+		{
+			description: "Comments and whitespace dropped",
+			query:       "/* api */ SELECT  1\n-- done",
+			expected:    "select ?",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, normalizeQuery(tc.query), tc.description)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fp := Fingerprint("SELECT * FROM users WHERE id = 1")
+
+	assert.Len(t, fp, 16)
+	assert.Equal(t, fp, Fingerprint("select *\nfrom users where id = 2 -- other"))
+	assert.Equal(t, Fingerprint("SELECT ? IN (1, 2)"), Fingerprint("SELECT ? IN (?)"))
+	assert.NotEqual(t, fp, Fingerprint("SELECT * FROM orders WHERE id = 1"))
+}
+
+func TestPgStatNormalize(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    string
+	}{
+		{
+			description: "Constants replaced in order",
+			query:       "SELECT * FROM users WHERE id = 42 AND name = 'alice'",
+			expected:    "SELECT * FROM users WHERE id = $1 AND name = $2",
+		},
+		{
+			description: "Numbered after the existing parameters",
+			query:       "SELECT * FROM users WHERE id = $2 AND age > 1.5e3 AND org = $1",
+			expected:    "SELECT * FROM users WHERE id = $2 AND age > $3 AND org = $1",
+		},
+		{
+			description: "Identifiers, keywords and comments kept",
+			query:       "SELECT \"col 1\", t2.x FROM t2 /* 7 */ WHERE flag = TRUE LIMIT 10",
+			expected:    "SELECT \"col 1\", t2.x FROM t2 /* 7 */ WHERE flag = TRUE LIMIT $1",
+		},
+		{
+			description: "Escaped and quoted strings",
+			query:       "INSERT INTO t (a, b) VALUES (E'a\\nb', 'it''s')",
+			expected:    "INSERT INTO t (a, b) VALUES ($1, $2)",
+		},
+		{
+			description: "Placeholders are constants",
+			query:       "SELECT * FROM t WHERE id IN (?, ?)",
+			expected:    "SELECT * FROM t WHERE id IN ($1, $2)",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, pgStatNormalize(tc.query), tc.description)
+	}
+}
+
+func TestUnquoteIdentifiers(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    string
+	}{
+		{
+			description: "Postgres identifiers unquoted",
+			query:       `SELECT "u"."id", "u"."name" FROM "users" AS "u" WHERE ("u"."name" = 'say "hi"')`,
+			expected:    `SELECT u.id, u.name FROM users AS u WHERE (u.name = 'say "hi"')`,
+		},
+		{
+			description: "MySQL identifiers unquoted",
+			query:       "SELECT `id` FROM `users` WHERE `name` = 'it''s `x`'",
+			expected:    "SELECT id FROM users WHERE name = 'it''s `x`'",
+		},
+		{
+			description: "Identifiers not valid unquoted kept",
+			query:       `SELECT "first name", "a""b" FROM "t"`,
+			expected:    `SELECT "first name", "a""b" FROM t`,
+		},
+		{
+			description: "Mixed case identifiers and reserved words kept",
+			query:       `SELECT "User"."Order", "user"."id" FROM "order" JOIN "user" USING ("id")`,
+			expected:    `SELECT "User"."Order", "user".id FROM "order" JOIN "user" USING (id)`,
+		},
+		{
+			description: "Unterminated quote kept",
+			query:       `SELECT "`,
+			expected:    `SELECT "`,
+		},
+		{
+			description: "Unterminated identifier kept",
+			query:       "SELECT `id",
+			expected:    "SELECT `id",
+		},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, unquoteIdentifiers(tc.query), tc.description)
+	}
+}
+
+func TestIsReadQuery(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    bool
+	}{
+		{description: "SELECT", query: "SELECT * FROM users", expected: true},
+		{description: "Locking SELECT", query: "SELECT * FROM users FOR NO KEY UPDATE", expected: true},
+		{description: "Parenthesized UNION", query: "(SELECT 1) UNION (SELECT 2)", expected: true},
+		{description: "Read CTE", query: "WITH u AS (SELECT id FROM users) SELECT * FROM u FOR UPDATE", expected: true},
+		{description: "EXPLAIN", query: "/* tag */ EXPLAIN SELECT 1", expected: true},
+		{description: "UPDATE", query: "UPDATE users SET name = 'select'", expected: false},
+		{description: "INSERT SELECT", query: "INSERT INTO t SELECT * FROM u", expected: false},
+		{description: "Write CTE", query: "WITH d AS (DELETE FROM users RETURNING id) SELECT * FROM d", expected: false},
+		{description: "CTE then write", query: "WITH u AS (SELECT id FROM users) DELETE FROM logs WHERE id IN (SELECT id FROM u)", expected: false},
+		{description: "DDL", query: "CREATE TABLE t (id int)", expected: false},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, isReadQuery(tc.query), tc.description)
+	}
+}
+
+func TestIsProbe(t *testing.T) {
+	for _, query := range []string{"SELECT 1", "select 1;", "/* ping */ SELECT 1", ";", ""} {
+		assert.True(t, isProbe(query), query)
+	}
+	for _, query := range []string{"SELECT 2", "SELECT 1 FROM users", "SELECT '1'"} {
+		assert.False(t, isProbe(query), query)
+	}
+}
+
+func TestLeadingComment(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    string
+	}{
+		{description: "Block comment", query: "/* app:billing */ SELECT 1", expected: "/* app:billing */"},
+		{description: "Leading blanks", query: "\n  /* app:billing */SELECT 1", expected: "/* app:billing */"},
+		{description: "Line comment", query: "-- billing job\nSELECT 1", expected: "-- billing job"},
+		{description: "Comment only", query: "-- nothing", expected: "-- nothing"},
+		{description: "Unterminated", query: "/* app:billing SELECT 1", expected: ""},
+		{description: "Trailing comment", query: "SELECT 1 /* app:billing */", expected: ""},
+		{description: "No comment", query: "SELECT 1", expected: ""},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, leadingComment(tc.query), tc.description)
+	}
+}
+
+func TestIsUnqualifiedWrite(t *testing.T) {
+	cases := []struct {
+		description string
+		query       string
+		expected    bool
+	}{
+		{description: "Unqualified DELETE", query: "DELETE FROM users", expected: true},
+		{description: "Unqualified UPDATE", query: "UPDATE users SET note = 'where is it'", expected: true},
+		{description: "WHERE in a subquery", query: "UPDATE users SET n = (SELECT count(*) FROM t WHERE t.id = 1)", expected: true},
+		{description: "Qualified DELETE", query: "DELETE FROM users WHERE id = 1", expected: false},
+		{description: "Qualified with a CTE", query: "WITH old AS (SELECT id FROM users) DELETE FROM users WHERE id IN (SELECT id FROM old)", expected: false},
+		{description: "Unqualified with a CTE", query: "WITH old AS (SELECT id FROM users WHERE id < 10) DELETE FROM logs", expected: true},
+		{description: "Read", query: "SELECT * FROM users", expected: false},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, isUnqualifiedWrite(tc.query), tc.description)
+	}
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the counters of a hook.
+type Stats struct {
+	// Queries is the number of queries seen by the hook, failed ones included.
+	Queries uint64
+	// Errors is the number of failed queries.
+	Errors uint64
+	// Dropped is the number of successful queries not logged because of
+	// WithMaxConcurrentLogs.
+	Dropped uint64
+}
+
+// HookGroup combines the stats of the hooks registered with WithGroup, e.g.
+// one per connection pool. The zero value is ready to use.
+type HookGroup struct {
+	stats queryStats
+}
+
+// Stats returns a snapshot of the counters of the hooks of the group,
+// combined.
+func (g *HookGroup) Stats() Stats {
+	return g.stats.snapshot()
+}
+
+// queryStats counts the queries, concurrently.
+type queryStats struct {
+	queries uint64
+	errors  uint64
+	dropped uint64
+}
+
+func (s *queryStats) record(failed bool) {
+	atomic.AddUint64(&s.queries, 1)
+	if failed {
+		atomic.AddUint64(&s.errors, 1)
+	}
+}
+
+func (s *queryStats) drop() {
+	atomic.AddUint64(&s.dropped, 1)
+}
+
+func (s *queryStats) snapshot() Stats {
+	return Stats{
+		Queries: atomic.LoadUint64(&s.queries),
+		Errors:  atomic.LoadUint64(&s.errors),
+		Dropped: atomic.LoadUint64(&s.dropped),
+	}
+}
+
+// flushEvery calls fn with a snapshot every interval until the returned
+// function is called.
+func (s *queryStats) flushEvery(interval time.Duration, fn func(Stats)) func() error {
+	return every(interval, func() { fn(s.snapshot()) })
+}
+
+// every calls fn every interval, in a goroutine, until the returned function
+// is called.
+func every(interval time.Duration, fn func()) func() error {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() error {
+		close(done)
+		<-stopped
+
+		return nil
+	}
+}
+
+// HistogramBucket counts the values lower than or equal to its upper bound,
+// and greater than the upper bound of the previous bucket.
+// The last bucket has math.MaxInt as upper bound.
+type HistogramBucket struct {
+	UpperBound int
+	Count      uint64
+}
+
+// histogram counts values into fixed buckets, concurrently.
+type histogram struct {
+	bounds []int
+	counts []uint64
+}
+
+func newHistogram(bounds []int) *histogram {
+	sorted := make([]int, len(bounds), len(bounds)+1)
+	copy(sorted, bounds)
+	sort.Ints(sorted)
+
+	sorted = append(sorted, math.MaxInt)
+
+	return &histogram{
+		bounds: sorted,
+		counts: make([]uint64, len(sorted)),
+	}
+}
+
+func (h *histogram) observe(v int) {
+	i := sort.SearchInts(h.bounds, v)
+	atomic.AddUint64(&h.counts[i], 1)
+}
+
+func (h *histogram) snapshot() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(h.bounds))
+	for i, bound := range h.bounds {
+		buckets[i] = HistogramBucket{
+			UpperBound: bound,
+			Count:      atomic.LoadUint64(&h.counts[i]),
+		}
+	}
+
+	return buckets
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestQueryHook_QueryLengthHistogram(t *testing.T) {
+	hook := NewQueryHook(zap.NewNop(), WithQueryLengthBuckets([]int{100, 10}))
+
+	for _, n := range []int{1, 10, 11, 100, 101, 5000} {
+		hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: strings.Repeat("x", n), StartTime: time.Now()})
+	}
+
+	assert.Equal(t, []HistogramBucket{
+		{UpperBound: 10, Count: 2},
+		{UpperBound: 100, Count: 2},
+		{UpperBound: math.MaxInt, Count: 2},
+	}, hook.QueryLengthHistogram())
+}
+
+func TestQueryHook_QueryLengthHistogramDisabled(t *testing.T) {
+	hook := NewQueryHook(zap.NewNop())
+
+	assert.Nil(t, hook.QueryLengthHistogram())
+}
+
+func TestQueryHook_Stats(t *testing.T) {
+	hook := NewQueryHook(zap.NewNop())
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT * FROM nop", StartTime: time.Now(), Err: errors.New("boom")})
+
+	assert.Equal(t, Stats{Queries: 2, Errors: 1}, hook.Stats())
+}
+
+func TestQueryHook_MaxConcurrentLogs(t *testing.T) {
+	ts := newTestLogSpy(t)
+	defer ts.AssertPassed()
+
+	hook := NewQueryHook(zaptest.NewLogger(ts), WithVerbose(true), WithMaxConcurrentLogs(1))
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+
+	// Saturated by a log in progress.
+	hook.logSlots <- struct{}{}
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 2", StartTime: time.Now()})
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 3", StartTime: time.Now(), Err: errors.New("boom")})
+	<-hook.logSlots
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 4", StartTime: time.Now()})
+
+	ts.AssertMessages("Successful queries dropped while saturated",
+		"DEBUG\tSELECT 1",
+		"ERROR\tSELECT 3 error: boom",
+		"DEBUG\tSELECT 4",
+	)
+	assert.Equal(t, Stats{Queries: 4, Errors: 1, Dropped: 1}, hook.Stats())
+}
+
+func TestQueryHook_MaxConcurrentLogsNonPositive(t *testing.T) {
+	ts := newTestLogSpy(t)
+	defer ts.AssertPassed()
+
+	for _, n := range []int{0, -1} {
+		hook := NewQueryHook(zaptest.NewLogger(ts), WithVerbose(true), WithMaxConcurrentLogs(n))
+		hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+	}
+
+	ts.AssertMessages("Option ignored", "DEBUG\tSELECT 1", "DEBUG\tSELECT 1")
+}
+
+func TestQueryHook_StatsFlush(t *testing.T) {
+	var calls int64
+	flushed := make(chan Stats, 100)
+
+	hook, cleanup := New(zap.NewNop(), WithStatsFlush(5*time.Millisecond, func(stats Stats) {
+		atomic.AddInt64(&calls, 1)
+		flushed <- stats
+	}))
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT * FROM nop", StartTime: time.Now(), Err: errors.New("boom")})
+
+	timeout := time.After(time.Second)
+	for stats := (Stats{}); stats != (Stats{Queries: 2, Errors: 1}); {
+		select {
+		case stats = <-flushed:
+		case <-timeout:
+			require.FailNow(t, "Stats not flushed")
+		}
+	}
+
+	require.NoError(t, cleanup())
+
+	stopped := atomic.LoadInt64(&calls)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt64(&calls), "No flush after cleanup")
+}
+
+func TestQueryHook_StatsFlushClose(t *testing.T) {
+	var calls int64
+
+	hook := NewQueryHook(zap.NewNop(), WithStatsFlush(time.Millisecond, func(Stats) {
+		atomic.AddInt64(&calls, 1)
+	}))
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&calls) > 0
+	}, time.Second, time.Millisecond, "Stats flushed")
+	require.NoError(t, hook.Close())
+	require.NoError(t, hook.Close(), "Safe to call more than once")
+
+	stopped := atomic.LoadInt64(&calls)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt64(&calls), "No flush after Close")
+}
+
+func TestQueryHook_StatsFlushNonPositiveInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		hook := NewQueryHook(zap.NewNop(), WithStatsFlush(interval, func(Stats) {}))
+		assert.Empty(t, hook.closers, "Ignored")
+		require.NoError(t, hook.Close())
+	}
+}
+
+func TestQueryHook_WindowSummary(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	hook, cleanup := New(zap.New(core), WithVerbose(true), WithSummaryOnly(), WithWindowSummary(5*time.Millisecond, zapcore.InfoLevel))
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 2", StartTime: time.Now()})
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "INSERT INTO t VALUES (1)", StartTime: time.Now()})
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT * FROM nop", StartTime: time.Now(), Err: errors.New("boom")})
+
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("query summary").Len() > 0
+	}, time.Second, time.Millisecond, "Summary logged every interval")
+	require.NoError(t, cleanup())
+
+	var queries, failed int64
+	for _, entry := range logs.AllUntimed() {
+		if entry.Message != "query summary" {
+			assert.Equal(t, "SELECT * FROM nop error: boom", entry.Message, "Only errors logged individually")
+			continue
+		}
+		assert.Equal(t, zapcore.InfoLevel, entry.Level)
+		queries += entry.ContextMap()["queries"].(int64)
+		failed += entry.ContextMap()["errors"].(int64)
+	}
+	assert.Equal(t, int64(4), queries)
+	assert.Equal(t, int64(1), failed)
+}
+
+func TestQueryHook_WindowSummaryClose(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+
+	hook := NewQueryHook(zap.New(core), WithWindowSummary(time.Millisecond, zapcore.InfoLevel))
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+	require.NoError(t, hook.Close())
+
+	summaries := logs.FilterMessage("query summary").Len()
+	require.Equal(t, 1, summaries, "Summary of the query logged by Close at the latest")
+
+	hook.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, summaries, logs.FilterMessage("query summary").Len(), "No summary after Close")
+}
+
+func TestWindowSummary(t *testing.T) {
+	summary := newWindowSummary()
+	assert.Nil(t, summary.flush(), "No queries")
+
+	for i := 1; i <= 20; i++ {
+		summary.observe("SELECT", time.Duration(i)*time.Millisecond, false)
+	}
+	summary.observe("INSERT", time.Millisecond, true)
+	summary.observe("INSERT", time.Millisecond, false)
+	summary.observe("UPDATE", time.Millisecond, false)
+	summary.observe("DELETE", time.Millisecond, false)
+
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range summary.flush() {
+		f.AddTo(enc)
+	}
+	assert.Equal(t, map[string]interface{}{
+		"queries":        int64(24),
+		"errors":         int64(1),
+		"p50":            8 * time.Millisecond,
+		"p95":            19 * time.Millisecond,
+		"top_operations": map[string]interface{}{"SELECT": 20, "INSERT": 2, "DELETE": 1},
+	}, enc.Fields)
+	assert.Nil(t, summary.flush(), "New window")
+}
+
+func TestHookGroup_Stats(t *testing.T) {
+	var group HookGroup
+	primary := NewQueryHook(zap.NewNop(), WithGroup(&group))
+	replica := NewQueryHook(zap.NewNop(), WithGroup(&group))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			primary.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT 1", StartTime: time.Now()})
+		}()
+		go func() {
+			defer wg.Done()
+			replica.AfterQuery(context.Background(), &bun.QueryEvent{Query: "SELECT * FROM nop", StartTime: time.Now(), Err: errors.New("boom")})
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, Stats{Queries: 10}, primary.Stats())
+	assert.Equal(t, Stats{Queries: 10, Errors: 10}, replica.Stats())
+	assert.Equal(t, Stats{Queries: 20, Errors: 10}, group.Stats())
+}
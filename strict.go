@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+)
+
+// checkOptions returns an error when two options set the same setting of the
+// hook to different values, that is when the outcome of applying them depends
+// on their order. Options adding to a list, such as WithObserver, do not
+// conflict.
+func checkOptions(logger *zap.Logger, opts []Option) error {
+	for i := range opts {
+		for j := i + 1; j < len(opts); j++ {
+			if f, ok := conflict(logger, opts[i], opts[j]); ok {
+				return fmt.Errorf("options #%d and #%d conflict on %s", i+1, j+1, f)
+			}
+		}
+	}
+
+	return nil
+}
+
+// conflict returns the first setting for which applying a then b differs
+// from applying b then a.
+func conflict(logger *zap.Logger, a, b Option) (string, bool) {
+	apply := func(opts ...Option) reflect.Value {
+		h := defaultQueryHook(logger)
+		for _, opt := range opts {
+			opt(h)
+		}
+		return reflect.ValueOf(h).Elem()
+	}
+
+	defaults, onlyA, onlyB := apply(), apply(a), apply(b)
+	ab, ba := apply(a, b), apply(b, a)
+
+	for f := 0; f < ab.NumField(); f++ {
+		if equalValues(ab.Field(f), ba.Field(f), map[[2]uintptr]bool{}) {
+			continue
+		}
+		// Both values kept in a list, in a different order.
+		if ab.Field(f).Kind() == reflect.Slice &&
+			ab.Field(f).Len() == onlyA.Field(f).Len()+onlyB.Field(f).Len()-defaults.Field(f).Len() {
+			continue
+		}
+
+		return ab.Type().Field(f).Name, true
+	}
+
+	return "", false
+}
+
+// differingField returns the first setting whose value differs between the
+// hooks.
+func differingField(a, b *QueryHook) (string, bool) {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for f := 0; f < va.NumField(); f++ {
+		if !equalValues(va.Field(f), vb.Field(f), map[[2]uintptr]bool{}) {
+			return va.Type().Field(f).Name, true
+		}
+	}
+
+	return "", false
+}
+
+// equalValues compares values deeply, unexported fields included. Functions
+// are equal when they share the same code, channels when they have the same
+// capacity. Pointers already being compared,
+// tracked in visited, are assumed equal to end cycles.
+func equalValues(a, b reflect.Value, visited map[[2]uintptr]bool) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Map:
+		pair := [2]uintptr{a.Pointer(), b.Pointer()}
+		if visited[pair] {
+			return true
+		}
+		visited[pair] = true
+	}
+
+	switch a.Kind() {
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Chan:
+		return a.IsNil() == b.IsNil() && a.Cap() == b.Cap()
+	case reflect.Func, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Ptr:
+		if a.Pointer() == b.Pointer() {
+			return true
+		}
+		if a.IsNil() || b.IsNil() {
+			return false
+		}
+		return equalValues(a.Elem(), b.Elem(), visited)
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return a.Elem().Type() == b.Elem().Type() && equalValues(a.Elem(), b.Elem(), visited)
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalValues(a.Index(i), b.Index(i), visited) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for iter := a.MapRange(); iter.Next(); {
+			v := b.MapIndex(iter.Key())
+			if !v.IsValid() || !equalValues(iter.Value(), v, visited) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !equalValues(a.Field(i), b.Field(i), visited) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
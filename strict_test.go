@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNewQueryHookChecked(t *testing.T) {
+	observe := func(ctx context.Context, event *bun.QueryEvent, dur time.Duration) {}
+	observeSlow := func(ctx context.Context, event *bun.QueryEvent, dur time.Duration) { _ = dur > time.Second }
+
+	cases := []struct {
+		description string
+		opts        []Option
+		expectedErr string
+	}{
+		{
+			description: "Conflicting options without strict mode",
+			opts:        []Option{WithVerbose(true), WithVerbose(false)},
+		},
+		{
+			description: "Conflicting options",
+			opts:        []Option{WithStrictOptions(), WithVerbose(true), WithDuration(), WithVerbose(false)},
+			expectedErr: "options #2 and #4 conflict on verbose",
+		},
+		{
+			description: "Conflicting levels",
+			opts:        []Option{WithStrictOptions(), WithLevels(zapcore.InfoLevel, zapcore.ErrorLevel), WithLevels(zapcore.DebugLevel, zapcore.ErrorLevel)},
+			expectedErr: "options #2 and #3 conflict on queryLevel",
+		},
+		{
+			description: "Same option twice with the same value",
+			opts: []Option{
+				WithStrictOptions(),
+				WithVerbose(true), WithVerbose(true),
+				WithTableFilter("users"), WithTableFilter("users"),
+				WithMessageTemplate("{{.Query}}"), WithMessageTemplate("{{.Query}}"),
+			},
+		},
+		{
+			description: "Same channel capacity twice",
+			opts:        []Option{WithStrictOptions(), WithMaxConcurrentLogs(2), WithMaxConcurrentLogs(2)},
+		},
+		{
+			description: "Conflicting channel capacities",
+			opts:        []Option{WithStrictOptions(), WithMaxConcurrentLogs(2), WithMaxConcurrentLogs(3)},
+			expectedErr: "options #2 and #3 conflict on logSlots",
+		},
+		{
+			description: "Options adding to a list",
+			opts:        []Option{WithStrictOptions(), WithObserver(observe), WithObserver(observeSlow)},
+		},
+	}
+
+	for _, tc := range cases {
+		hook, err := NewQueryHookChecked(zap.NewNop(), tc.opts...)
+		if tc.expectedErr != "" {
+			assert.EqualError(t, err, tc.expectedErr, tc.description)
+			assert.Nil(t, hook, tc.description)
+			continue
+		}
+		require.NoError(t, err, tc.description)
+		assert.NotNil(t, hook, tc.description)
+	}
+}
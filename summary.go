@@ -0,0 +1,100 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// summaryTopOperations is the number of most frequent operations listed in
+// a window summary.
+const summaryTopOperations = 3
+
+// windowSummary aggregates the queries of the current window, concurrently.
+type windowSummary struct {
+	mu         sync.Mutex
+	durations  []time.Duration
+	errors     int
+	operations map[string]int
+}
+
+func newWindowSummary() *windowSummary {
+	return &windowSummary{operations: make(map[string]int)}
+}
+
+func (w *windowSummary) observe(operation string, dur time.Duration, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.durations = append(w.durations, dur)
+	if failed {
+		w.errors++
+	}
+	w.operations[operation]++
+}
+
+// flush returns the summary fields of the window and starts a new one, or
+// returns nil when there were no queries.
+func (w *windowSummary) flush() []zap.Field {
+	w.mu.Lock()
+	durations, errors, operations := w.durations, w.errors, w.operations
+	w.durations, w.errors, w.operations = nil, 0, make(map[string]int)
+	w.mu.Unlock()
+
+	if len(durations) == 0 {
+		return nil
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	top := make(operationCounts, 0, len(operations))
+	for op, count := range operations {
+		top = append(top, operationCount{op: op, count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].op < top[j].op
+	})
+	if len(top) > summaryTopOperations {
+		top = top[:summaryTopOperations]
+	}
+
+	return []zap.Field{
+		zap.Int("queries", len(durations)),
+		zap.Int("errors", errors),
+		zap.Duration("p50", percentile(durations, 50)),
+		zap.Duration("p95", percentile(durations, 95)),
+		zap.Object("top_operations", top),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+type operationCount struct {
+	op    string
+	count int
+}
+
+// operationCounts logs the query counts by operation, in order.
+type operationCounts []operationCount
+
+func (c operationCounts) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, oc := range c {
+		enc.AddInt(oc.op, oc.count)
+	}
+
+	return nil
+}